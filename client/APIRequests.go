@@ -7,78 +7,85 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
-func getAllFeedEntries(config FeedieConfig, offset int) []list_entry {
-	entries := []list_entry{}
+// authTransport adds the configured token to requests to the server, and
+// only to those, since downloads and thumbnails go through the same client.
+type authTransport struct {
+	base   http.RoundTripper
+	server string
+	token  string
+}
 
-	resp, err := http.Get(fmt.Sprintf("%s%s/get_entries?method=all&limit=%d&offset=%d",
-		config.SERVER, config.PORT, config.EntryLimit, offset*config.EntryLimit))
-	if err != nil {
-		log.Println(err)
-		return entries
+func (t authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(r.URL.String(), t.server) {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+t.token)
 	}
-	defer resp.Body.Close()
+	return t.base.RoundTrip(r)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Bad StatusCode")
-		return entries
+// configureAuth makes requests to the server carry the token from the
+// config, for servers that require one.
+func configureAuth(config FeedieConfig) {
+	if config.Token == "" {
+		return
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-		log.Fatal(err)
+	http.DefaultTransport = authTransport{
+		base:   http.DefaultTransport,
+		server: config.SERVER + config.PORT,
+		token:  config.Token,
 	}
-
-	return entries
 }
 
-func getSrcFunc(srcType SourceType, rawKey string) func(FeedieConfig, int) []list_entry {
-	escapedKey := url.QueryEscape(rawKey)
+func getAllFeedEntries(config FeedieConfig, opts entry_options, after string) entry_page {
+	return getEntrySummaries(config, "all", "", opts, after)
+}
 
+func getSrcFunc(srcType SourceType, rawKey string) func(FeedieConfig, entry_options, string) entry_page {
 	switch srcType {
 	case Tag:
-		return func(config FeedieConfig, offset int) []list_entry {
-			entries := []list_entry{}
-			resp, err := http.Get(fmt.Sprintf("%s%s/get_entries?method=by_tag&value=%s&limit=%d&offset=%d",
-				config.SERVER, config.PORT, escapedKey, config.EntryLimit, offset*config.EntryLimit))
-			if err != nil {
-				log.Println(err)
-				return entries
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				log.Println("Bad StatusCode")
-				return entries
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-				log.Fatal(err)
-			}
-			return entries
+		return func(config FeedieConfig, opts entry_options, after string) entry_page {
+			return getEntrySummaries(config, "by_tag", rawKey, opts, after)
 		}
 	case Feed:
-		return func(config FeedieConfig, offset int) []list_entry {
-			entries := []list_entry{}
-			resp, err := http.Get(fmt.Sprintf("%s%s/get_entries?method=by_feed&value=%s&limit=%d&offset=%d",
-				config.SERVER, config.PORT, escapedKey, config.EntryLimit, offset*config.EntryLimit))
-			if err != nil {
-				log.Println(err)
-				return entries
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				log.Println("Bad StatusCode", resp.StatusCode)
-				return entries
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
-				log.Fatal(err)
-			}
-			return entries
+		return func(config FeedieConfig, opts entry_options, after string) entry_page {
+			return getEntrySummaries(config, "by_feed", rawKey, opts, after)
 		}
 	}
-	return func(config FeedieConfig, offset int) []list_entry {
-		_, _ = config, offset
-		return []list_entry{}
+	return func(config FeedieConfig, opts entry_options, after string) entry_page {
+		_, _, _ = config, opts, after
+		return entry_page{}
+	}
+}
+
+// getEntrySummaries fetches the page of entries listed with opts after the
+// cursor after, or the first page when it is empty. Summaries leave out the content of the
+// entries, which getEntry fetches for the entry selected.
+func getEntrySummaries(config FeedieConfig, method, value string, opts entry_options, after string) entry_page {
+	page := entry_page{Entries: []list_entry{}}
+	q := url.Values{"method": {method}, "summary": {""},
+		"limit": {fmt.Sprint(config.EntryLimit)}, "after": {after}}
+	if value != "" {
+		q.Set("value", value)
+	}
+	opts.apply(q)
+	if err := apiGetRevalidated(config, "/get_entries", q, &page); err != nil {
+		log.Println(err)
+		return entry_page{}
 	}
+	for i := range page.Entries {
+		page.Entries[i].summary = true
+	}
+	return page
+}
+
+func getEntry(config FeedieConfig, id int64) (list_entry, error) {
+	var entry list_entry
+	err := apiGet(config, "/get_entry", url.Values{"id": {fmt.Sprint(id)}}, &entry)
+	return entry, err
 }
 
 type ActionType int 
@@ -88,6 +95,9 @@ const(
 	addTag_t
 	delTag_t
 	modTagMember_t
+	delRule_t
+	setTagPublic_t
+	setFeedFullText_t
 )
 
 func getActionFunc (at ActionType) func (FeedieConfig, []string) error {
@@ -197,6 +207,63 @@ func getActionFunc (at ActionType) func (FeedieConfig, []string) error {
 				}
 				return nil
 			}
+		case setTagPublic_t:
+			return func(config FeedieConfig, params []string) error{
+				if len(params) != 2 {return errors.New("Invalid parameter count")}
+
+				resp, err := http.Get(fmt.Sprintf("%s%s/set_tag_public?tag_name=%s&public=%s",
+					config.SERVER,config.PORT,url.QueryEscape(params[0]),url.QueryEscape(params[1]))); if err != nil{
+					log.Println(err)
+					return err
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK{
+					log.Println("Bad StatusCode", resp.StatusCode)
+					return fmt.Errorf("invalid tag: %s", params[0])
+				}
+
+				return nil
+			}
+		case setFeedFullText_t:
+			return func(config FeedieConfig, params []string) error{
+				if len(params) != 2 {return errors.New("Invalid parameter count")}
+
+				resp, err := http.Get(fmt.Sprintf("%s%s/set_feed_full_text?feed_url=%s&enabled=%s",
+					config.SERVER,config.PORT,url.QueryEscape(params[0]),url.QueryEscape(params[1]))); if err != nil{
+					log.Println(err)
+					return err
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK{
+					log.Println("Bad StatusCode", resp.StatusCode)
+					return fmt.Errorf("invalid feed url: %s", params[0])
+				}
+
+				return nil
+			}
+		case delRule_t:
+			return func(config FeedieConfig, params []string) error{
+				// the list popup passes the selected item's title and url,
+				// the rule id is carried in the url
+				if len(params) < 1 {return errors.New("Invalid parameter count")}
+				id := params[len(params)-1]
+
+				resp, err := http.Get(fmt.Sprintf("%s%s/del_rule?id=%s",
+					config.SERVER,config.PORT,url.QueryEscape(id))); if err != nil{
+					log.Println(err)
+					return err
+				}
+				defer resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK{
+					log.Println("Bad StatusCode", resp.StatusCode)
+					return fmt.Errorf("invalid rule: %s", id)
+				}
+
+				return nil
+			}
 
 			}
 	
@@ -356,3 +423,189 @@ func getModTagOptions(config FeedieConfig, tag string) []popUpListItem{
 
 	return ret
 }
+
+type feedieRule struct{
+	ID string
+	Field string
+	MatchType string
+	Pattern string
+	Scope string
+	ScopeValue string
+	Action string
+	ActionValue string
+}
+
+func (r feedieRule) summary() string{
+	scope := r.Scope
+	if r.ScopeValue != ""{
+		scope = fmt.Sprintf("%s %s", r.Scope, r.ScopeValue)
+	}
+	action := r.Action
+	if r.ActionValue != ""{
+		action = fmt.Sprintf("%s:%s", r.Action, r.ActionValue)
+	}
+	pattern := r.Pattern
+	if r.MatchType == "regex"{
+		pattern = "/" + r.Pattern + "/"
+	}
+	return fmt.Sprintf("[%s] %s %s %s", scope, action, r.Field, pattern)
+}
+
+// parseRule reads the rule syntax used by the add rule popup:
+//   <action>[:<tag>] <field> <pattern>
+// a pattern wrapped in slashes is a regex, anything else is a list of keywords.
+func parseRule(text string) (feedieRule, error){
+	r := feedieRule{}
+	parts := strings.SplitN(strings.TrimSpace(text), " ", 3)
+	if len(parts) != 3 {
+		return r, errors.New("expected: <action>[:<tag>] <field> <pattern>")
+	}
+	r.Action, r.ActionValue, _ = strings.Cut(parts[0], ":")
+	r.Field = parts[1]
+	pattern := strings.TrimSpace(parts[2])
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"){
+		r.MatchType = "regex"
+		r.Pattern = pattern[1:len(pattern)-1]
+	} else{
+		r.MatchType = "keyword"
+		r.Pattern = pattern
+	}
+	return r, nil
+}
+
+// getAddRuleAction returns an action adding the rule typed into a text popup,
+// scoped to the given source. The "All feeds" source makes a global rule.
+func getAddRuleAction(src list_source) func(FeedieConfig, []string) error{
+	return func(config FeedieConfig, params []string) error{
+		if len(params) != 1 {return errors.New("Invalid parameter count")}
+		rule, err := parseRule(params[0])
+		if err != nil{
+			return err
+		}
+		switch{
+		case src.SrcType == Feed:
+			rule.Scope, rule.ScopeValue = "feed", src.Url
+		case src.SrcType == Tag && src.Url != "":
+			rule.Scope, rule.ScopeValue = "tag", src.Title_field
+		default:
+			rule.Scope = "global"
+		}
+		q := url.Values{}
+		q.Set("field", rule.Field)
+		q.Set("match_type", rule.MatchType)
+		q.Set("pattern", rule.Pattern)
+		q.Set("scope", rule.Scope)
+		q.Set("scope_value", rule.ScopeValue)
+		q.Set("action", rule.Action)
+		q.Set("action_value", rule.ActionValue)
+
+		resp, err := http.Get(fmt.Sprintf("%s%s/add_rule?%s",
+			config.SERVER,config.PORT,q.Encode())); if err != nil{
+			log.Println(err)
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK{
+			log.Println("Bad StatusCode", resp.StatusCode)
+			return fmt.Errorf("invalid rule: %s", params[0])
+		}
+		return nil
+	}
+}
+
+func getRuleOptions(config FeedieConfig) []popUpListItem{
+	ret := []popUpListItem{}
+	resp, err := http.Get(fmt.Sprintf("%s%s/get_rules",config.SERVER,config.PORT))
+	if err != nil{
+		log.Println(err)
+		return ret
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK{
+		log.Println("Bad StatusCode")
+		return ret
+	}
+	var rules []feedieRule
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range rules{
+		ret = append(ret, popUpListItem{Title_Field: r.summary(), Url: r.ID})
+	}
+	return ret
+}
+
+type feedEvent struct{
+	Time int64
+	Event string
+	Detail string
+}
+
+func (e feedEvent) summary() string{
+	when := time.Unix(e.Time, 0).Format("2006-01-02 15:04")
+	if e.Detail != ""{
+		return fmt.Sprintf("%s %s %s", when, e.Event, e.Detail)
+	}
+	return fmt.Sprintf("%s %s", when, e.Event)
+}
+
+// getFeedHistory fetches what happened to the feed at feedURL, newest first.
+func getFeedHistory(config FeedieConfig, feedURL string) ([]feedEvent, error){
+	events := []feedEvent{}
+	err := apiGet(config, "/get_feed_history", url.Values{"feed_url": {feedURL}}, &events)
+	return events, err
+}
+
+func getFeedHistoryOptions(config FeedieConfig, feedURL string) []popUpListItem{
+	ret := []popUpListItem{}
+	events, err := getFeedHistory(config, feedURL)
+	if err != nil{
+		log.Println(err)
+		return ret
+	}
+	if len(events) == 0{
+		return append(ret, popUpListItem{Title_Field: "Nothing has happened to this feed yet"})
+	}
+	for _, e := range events{
+		ret = append(ret, popUpListItem{Title_Field: e.summary()})
+	}
+	return ret
+}
+
+// getArticle fetches the full article the server extracted from link.
+func getArticle(config FeedieConfig, link string) (string, error){
+	resp, err := http.Get(fmt.Sprintf("%s%s/get_article?url=%s",
+		config.SERVER,config.PORT,url.QueryEscape(link)))
+	if err != nil{
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK{
+		return "", fmt.Errorf("unable to extract article: %s", resp.Status)
+	}
+	var article struct{
+		Content string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&article); err != nil {
+		return "", err
+	}
+	return article.Content, nil
+}
+
+// setPosition records the playback position of the enclosure at link.
+func setPosition(config FeedieConfig, link string, position int64) error{
+	resp, err := http.Get(fmt.Sprintf("%s%s/set_position?url=%s&position=%d",
+		config.SERVER,config.PORT,url.QueryEscape(link),position))
+	if err != nil{
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK{
+		return fmt.Errorf("unable to save position: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Non-interactive commands, for scripts and launchers such as dmenu or rofi.
+// Human output is one tab separated record per line, --json prints the
+// server's objects instead.
+
+type clientCommand struct {
+	args    string // argument synopsis for the usage line
+	summary string
+	// flags registers the command's flags and returns the function running it
+	flags func(fs *flag.FlagSet) func(config FeedieConfig, args []string) error
+}
+
+var clientCommands map[string]clientCommand
+
+// clientCommandOrder is the order commands are listed in the usage text
+var clientCommandOrder = []string{"tags", "feeds", "entries", "add-feed", "del-feed", "del-tag", "read", "open", "history"}
+
+func init() {
+	clientCommands = map[string]clientCommand{
+		"tags":     {"", "List tags", tagsFlags},
+		"feeds":    {"", "List feeds as title and url", feedsFlags},
+		"entries":  {"", "List entries as date, title and link", entriesFlags},
+		"add-feed": {"<url>", "Add a feed", addFeedFlags},
+		"del-feed": {"<url>...", "Delete feeds", delFeedFlags},
+		"del-tag":  {"<name>...", "Delete tags", delTagFlags},
+		"read":     {"<link|id>...", "Mark the entries with these links or ids read", readFlags},
+		"open":     {"<link>", "Open a link with the configured opener", openFlags},
+		"history":  {"<url>", "Show what happened to a feed: moves and going away", historyFlags},
+	}
+}
+
+func commandUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: feedie [-c config] [command] [flags]\n\nWithout a command the interactive client starts.\n\nCommands:\n")
+	for _, name := range clientCommandOrder {
+		fmt.Fprintf(w, "  %-10s %s\n", name, clientCommands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun 'feedie <command> --help' for the flags of a command.\n")
+}
+
+// runCommand runs the command named by args[0] and returns the exit status.
+func runCommand(config FeedieConfig, args []string) int {
+	name := args[0]
+	c, ok := clientCommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "feedie: unknown command %q\n\n", name)
+		commandUsage(os.Stderr)
+		return 2
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	run := c.flags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: feedie %s [flags] %s\n\n%s\n", name, c.args, c.summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if err := run(config, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "feedie %s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// apiGet requests path from the server and decodes the JSON response into
+// v, unless v is nil.
+func apiGet(config FeedieConfig, path string, query url.Values, v any) error {
+	resp, err := http.Get(apiURL(config, path, query))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// revalidatedSize bounds the number of responses apiGetRevalidated keeps.
+const revalidatedSize = 64
+
+type etaggedResponse struct {
+	etag string
+	body []byte
+}
+
+var revalidated = struct {
+	sync.Mutex
+	responses map[string]etaggedResponse
+}{responses: make(map[string]etaggedResponse)}
+
+// apiGetRevalidated is apiGet for responses the server tags with an ETag.
+// The last response for the same query is kept, and decoded again for as
+// long as the server answers it is unchanged.
+func apiGetRevalidated(config FeedieConfig, path string, query url.Values, v any) error {
+	u := apiURL(config, path, query)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	revalidated.Lock()
+	prev, ok := revalidated.responses[u]
+	revalidated.Unlock()
+	if ok {
+		req.Header.Set("If-None-Match", prev.etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body := prev.body
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+	case resp.StatusCode == http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return err
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			revalidated.Lock()
+			if len(revalidated.responses) >= revalidatedSize {
+				for k := range revalidated.responses {
+					delete(revalidated.responses, k)
+					break
+				}
+			}
+			revalidated.responses[u] = etaggedResponse{etag: etag, body: body}
+			revalidated.Unlock()
+		}
+	default:
+		return statusError(resp)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func apiURL(config FeedieConfig, path string, query url.Values) string {
+	return fmt.Sprintf("%s%s%s?%s", config.SERVER, config.PORT, path, query.Encode())
+}
+
+// statusError returns the error of a response that wasn't a success.
+func statusError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if m := strings.TrimSpace(string(msg)); m != "" {
+		return fmt.Errorf("%s: %s", resp.Status, m)
+	}
+	return errors.New(resp.Status)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+func needArgs(args []string, what string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected %s", what)
+	}
+	return nil
+}
+
+func tagsFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	asJSON := fs.Bool("json", false, "print JSON")
+	return func(config FeedieConfig, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		tags := []struct {
+			ID     int64
+			Title  string
+			Public bool
+		}{}
+		if err := apiGet(config, "/get_tags", url.Values{}, &tags); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(tags)
+		}
+		for _, t := range tags {
+			fmt.Println(t.Title)
+		}
+		return nil
+	}
+}
+
+func feedsFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	tag := fs.String("tag", "", "only list feeds in this tag")
+	asJSON := fs.Bool("json", false, "print JSON")
+	return func(config FeedieConfig, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		q := url.Values{"method": {"all"}}
+		if *tag != "" {
+			q = url.Values{"method": {"by_tag"}, "tag_name": {*tag}}
+		}
+		feeds := []struct {
+			ID       int64
+			Title    string
+			Url      string
+			FullText bool
+			Dead     bool
+		}{}
+		if err := apiGet(config, "/get_feeds", q, &feeds); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(feeds)
+		}
+		for _, f := range feeds {
+			fmt.Printf("%s\t%s\n", stripZWC(f.Title), f.Url)
+		}
+		return nil
+	}
+}
+
+func entriesFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	tag := fs.String("tag", "", "only entries of feeds in this tag")
+	feed := fs.String("feed", "", "only entries of the feed with this title")
+	search := fs.String("search", "", "only entries matching this search")
+	unread := fs.Bool("unread", false, "only unread entries")
+	starred := fs.Bool("starred", false, "only starred entries")
+	limit := fs.Int("limit", 50, "maximum number of entries, newest first")
+	asJSON := fs.Bool("json", false, "print JSON")
+	return func(config FeedieConfig, args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		q := url.Values{"method": {"all"}}
+		sources := 0
+		for _, s := range []struct{ method, value string }{
+			{"by_tag", *tag}, {"by_feed", *feed}, {"search", *search},
+		} {
+			if s.value != "" {
+				q = url.Values{"method": {s.method}, "value": {s.value}}
+				sources++
+			}
+		}
+		if sources > 1 {
+			return errors.New("only one of --tag, --feed and --search can be given")
+		}
+		if *limit < 1 {
+			return errors.New("--limit must be positive")
+		}
+
+		// read and starred are filtered here, so keep paging until there
+		// are enough matches or the server runs out of entries
+		entries := []list_entry{}
+		for offset := 0; len(entries) < *limit; offset += *limit {
+			q.Set("limit", fmt.Sprint(*limit))
+			q.Set("offset", fmt.Sprint(offset))
+			page := []list_entry{}
+			if err := apiGet(config, "/get_entries", q, &page); err != nil {
+				return err
+			}
+			for _, e := range page {
+				if (*unread && e.Read) || (*starred && !e.Starred) {
+					continue
+				}
+				entries = append(entries, e)
+			}
+			if len(page) < *limit {
+				break
+			}
+		}
+		entries = entries[:min(len(entries), *limit)]
+
+		if *asJSON {
+			return printJSON(entries)
+		}
+		for _, e := range entries {
+			published := time.Unix(int64(e.Published), 0).Format("2006-01-02")
+			fmt.Printf("%s\t%s\t%s\n", published, stripZWC(e.Title_field), e.link())
+		}
+		return nil
+	}
+}
+
+func addFeedFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	tag := fs.String("tag", "", "add the feed to this tag")
+	return func(config FeedieConfig, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected one feed url")
+		}
+		if err := getActionFunc(addFeed_t)(config, args); err != nil {
+			return err
+		}
+		if *tag == "" {
+			return nil
+		}
+		if err := apiGet(config, "/add_tag", url.Values{"tag_name": {*tag}}, nil); err != nil {
+			return err
+		}
+		return apiGet(config, "/add_member", url.Values{"tag_name": {*tag}, "feed_url": {args[0]}}, nil)
+	}
+}
+
+func delFeedFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	return func(config FeedieConfig, args []string) error {
+		if err := needArgs(args, "feed urls"); err != nil {
+			return err
+		}
+		for _, feedURL := range args {
+			if err := apiGet(config, "/del_feed", url.Values{"feed_url": {feedURL}}, nil); err != nil {
+				return fmt.Errorf("%s: %w", feedURL, err)
+			}
+		}
+		return nil
+	}
+}
+
+func delTagFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	return func(config FeedieConfig, args []string) error {
+		if err := needArgs(args, "tag names"); err != nil {
+			return err
+		}
+		for _, tag := range args {
+			if err := apiGet(config, "/del_tag", url.Values{"tag_name": {tag}}, nil); err != nil {
+				return fmt.Errorf("%s: %w", tag, err)
+			}
+		}
+		return nil
+	}
+}
+
+func readFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	unread := fs.Bool("unread", false, "mark the entries unread instead")
+	return func(config FeedieConfig, args []string) error {
+		if err := needArgs(args, "entry links or ids"); err != nil {
+			return err
+		}
+		for _, arg := range args {
+			q := url.Values{"url": {arg}, "read": {fmt.Sprint(!*unread)}}
+			if _, err := strconv.ParseInt(arg, 10, 64); err == nil {
+				q = url.Values{"id": {arg}, "read": {fmt.Sprint(!*unread)}}
+			}
+			if err := apiGet(config, "/set_read", q, nil); err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+		}
+		return nil
+	}
+}
+
+func openFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	linkType := fs.String("type", "", "MIME type of the link, for the typeopener setting")
+	markRead := fs.Bool("read", false, "also mark the entry with this link read")
+	return func(config FeedieConfig, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected one link")
+		}
+		cmd, err := config.linkCommand(FeedieLink{URL: args[0], Type: *linkType})
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		// the opener keeps running after feedie exits
+		if err := cmd.Process.Release(); err != nil {
+			return err
+		}
+		if *markRead {
+			q := url.Values{"url": {args[0]}, "read": {"true"}}
+			return apiGet(config, "/set_read", q, nil)
+		}
+		return nil
+	}
+}
+
+func historyFlags(fs *flag.FlagSet) func(FeedieConfig, []string) error {
+	asJSON := fs.Bool("json", false, "print JSON")
+	return func(config FeedieConfig, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected a feed url")
+		}
+		events, err := getFeedHistory(config, args[0])
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(events)
+		}
+		for _, e := range events {
+			fmt.Printf("%s\t%s\t%s\n", time.Unix(e.Time, 0).Format(time.RFC3339), e.Event, e.Detail)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Enclosures are downloaded one at a time by a background worker. The
+// entries view polls the queue with downloadTickMsg to redraw progress.
+
+type downloadState int
+
+const (
+	downloadQueued downloadState = iota
+	downloadActive
+	downloadDone
+	downloadFailed
+)
+
+type download struct {
+	url   string
+	path  string
+	total int64
+	done  int64
+	state downloadState
+	err   error
+}
+
+type downloadQueue struct {
+	mu      sync.Mutex
+	items   []*download
+	running bool
+}
+
+var downloads downloadQueue
+
+const downloadTickRate = 500 * time.Millisecond
+
+type downloadTickMsg struct{}
+
+func downloadTick() tea.Cmd {
+	return tea.Tick(downloadTickRate, func(time.Time) tea.Msg { return downloadTickMsg{} })
+}
+
+// downloadPath returns where the enclosure at link is saved. Hosts often
+// serve every episode under the same name, so the name starts with the
+// hash of link.
+func downloadPath(config FeedieConfig, link string) string {
+	name := GetHashString(link)
+	if u, err := url.Parse(link); err == nil {
+		if base := path.Base(u.Path); base != "" && base != "/" && base != "." {
+			name += "-" + base
+		}
+	}
+	return filepath.Join(config.DownloadPath, name)
+}
+
+// enqueue adds link to the queue unless it is queued or downloaded already
+// and starts the worker if it isn't running.
+func (q *downloadQueue) enqueue(config FeedieConfig, link string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, d := range q.items {
+		if d.url == link && d.state != downloadFailed {
+			return
+		}
+	}
+	q.items = append(q.items, &download{url: link, path: downloadPath(config, link)})
+	if !q.running {
+		q.running = true
+		go q.work()
+	}
+}
+
+func (q *downloadQueue) next() *download {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, d := range q.items {
+		if d.state == downloadQueued {
+			d.state = downloadActive
+			return d
+		}
+	}
+	q.running = false
+	return nil
+}
+
+func (q *downloadQueue) work() {
+	for d := q.next(); d != nil; d = q.next() {
+		err := q.fetch(d)
+		q.mu.Lock()
+		if err != nil {
+			log.Println(err)
+			d.state = downloadFailed
+			d.err = err
+		} else {
+			d.state = downloadDone
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *downloadQueue) fetch(d *download) error {
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return err
+	}
+	resp, err := http.Get(d.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: %s", d.url, resp.Status)
+	}
+	q.mu.Lock()
+	d.total = resp.ContentLength
+	q.mu.Unlock()
+
+	// write to a temporary file so a partial download is never played
+	tmp := d.path + ".part"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, &progressReader{r: resp.Body, d: d, mu: &q.mu})
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+type progressReader struct {
+	r  io.Reader
+	d  *download
+	mu *sync.Mutex
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.mu.Lock()
+	p.d.done += int64(n)
+	p.mu.Unlock()
+	return n, err
+}
+
+// active reports whether any download is queued or in progress.
+func (q *downloadQueue) active() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running
+}
+
+// status describes the download of link, or returns "" if it was never
+// queued.
+func (q *downloadQueue) status(link string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, d := range q.items {
+		if d.url != link {
+			continue
+		}
+		switch d.state {
+		case downloadQueued:
+			return "queued"
+		case downloadActive:
+			if d.total > 0 {
+				return fmt.Sprintf("downloading %d%% (%s / %s)", d.done*100/d.total,
+					formatSize(d.done), formatSize(d.total))
+			}
+			return fmt.Sprintf("downloading %s", formatSize(d.done))
+		case downloadDone:
+			return "downloaded"
+		case downloadFailed:
+			return fmt.Sprintf("failed: %v", d.err)
+		}
+	}
+	return ""
+}
+
+// summary describes the whole queue, or returns "" when it is idle.
+func (q *downloadQueue) summary() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued, active := 0, 0
+	for _, d := range q.items {
+		switch d.state {
+		case downloadQueued:
+			queued++
+		case downloadActive:
+			active++
+		}
+	}
+	if queued+active == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Downloads: %d active, %d queued", active, queued)
+}
+
+func formatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(seconds int64) string {
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+type playbackDoneMsg struct {
+	link     string
+	position int64
+}
+
+// playEnclosure hands link, or its downloaded copy, to the configured
+// player starting at the entry's saved position. The player gets the
+// terminal until it exits; the position mpv reports last is saved, and for
+// other players the time they ran for is added to the position.
+func playEnclosure(config FeedieConfig, entry list_entry, link string) (tea.Cmd, error) {
+	if config.Player == "" {
+		return nil, errors.New("no player configured")
+	}
+	target := link
+	if p := downloadPath(config, link); fileExists(p) {
+		target = p
+	}
+	var start, duration int64
+	if entry.Podcast != nil {
+		start, duration = entry.Podcast.Position, entry.Podcast.Duration
+	}
+	args := strings.Fields(config.Player)
+	if start > 0 && config.PlayerStartArg != "" {
+		args = append(args, fmt.Sprintf(config.PlayerStartArg, start))
+	}
+	var watcher *playerPosition
+	if filepath.Base(args[0]) == "mpv" {
+		socket := mpvIPCSocket()
+		args = append(args, "--input-ipc-server="+socket)
+		watcher = watchPlayerPosition(socket)
+	}
+	args = append(args, target)
+	cmd := exec.Command(args[0], args[1:]...)
+
+	began := time.Now()
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		if err != nil {
+			log.Println(err)
+		}
+		position := start + int64(time.Since(began).Seconds())
+		if watcher != nil {
+			if pos, ok := watcher.position(); ok {
+				position = pos
+			}
+		}
+		if duration > 0 && position >= duration {
+			position = 0
+		}
+		if err := setPosition(config, link, position); err != nil {
+			log.Println(err)
+		}
+		return playbackDoneMsg{link: link, position: position}
+	}), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"log"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
@@ -16,17 +18,33 @@ type thumbnailReadyMsg struct {
 	url string
 }
 
+type articleReadyMsg struct {
+	link    string
+	content string
+}
+
+type entryReadyMsg struct {
+	entry list_entry
+}
+
 type entriesModel struct {
 	prevModel     tea.Model
 	config        FeedieConfig
-	src           func(FeedieConfig, int) []list_entry
+	src           func(FeedieConfig, entry_options, string) entry_page
 	width, height int
 	ready         bool
 	listFocused   bool
 	vp            viewport.Model
 	list          list.Model
 	thumbnail     thumbnailManager
-	maxPageOffset int
+	// pages of the list loaded, and the cursor to the page after them
+	pages       int
+	next        string
+	showArticle bool
+	options     entry_options
+	articles    map[string]string
+	// full entries fetched for the summaries listed, by id
+	entries map[int64]list_entry
 }
 
 func (m entriesModel) getSelectedEntry() list_entry {
@@ -39,7 +57,7 @@ func (m entriesModel) getSelectedEntry() list_entry {
 }
 
 func getEntryKeys(config FeedieConfig) func() []key.Binding {
-	entryCommands := []string{"changeFocus", "feedMenu", "openMenu", "open"}
+	entryCommands := []string{"changeFocus", "feedMenu", "openMenu", "sortMenu", "open", "fullArticle", "download", "play"}
 	ret := []key.Binding{}
 	for command, keys := range config.Keys {
 		if in(command, entryCommands) {
@@ -53,7 +71,7 @@ func getEntryKeys(config FeedieConfig) func() []key.Binding {
 	return func() []key.Binding { return ret }
 }
 
-func initialEntriesModel(src func(FeedieConfig, int) []list_entry, config FeedieConfig, prev tea.Model, initial []list_entry) entriesModel {
+func initialEntriesModel(src func(FeedieConfig, entry_options, string) entry_page, config FeedieConfig, prev tea.Model, initial entry_page, showFeed bool) entriesModel {
 	m := entriesModel{
 		prevModel:   prev,
 		config:      config,
@@ -63,8 +81,10 @@ func initialEntriesModel(src func(FeedieConfig, int) []list_entry, config Feedie
 		ready:       false,
 		listFocused: true,
 		vp:          viewport.New(defaultH, defaultW),
-		list:        list.New([]list.Item{}, config.getEntryDelegate(), defaultW, defaultH),
+		list:        list.New([]list.Item{}, config.getEntryDelegate(showFeed), defaultW, defaultH),
 		thumbnail:   initThumbnailManager(config),
+		articles:    make(map[string]string),
+		entries:     make(map[int64]list_entry),
 	}
 
 	m.vp.MouseWheelEnabled = true
@@ -114,16 +134,14 @@ func initialEntriesModel(src func(FeedieConfig, int) []list_entry, config Feedie
 	m.list.KeyMap = keyMap
 	m.list.AdditionalFullHelpKeys = getEntryKeys(config)
 
+	if len(initial.Entries) == 0 {
+		initial = m.src(m.config, m.options, "")
+	}
 	var entries []list.Item
-	if len(initial) > 0 {
-		for _, entry := range initial {
-			entries = append(entries, entry)
-		}
-	} else {
-		for _, entry := range m.src(m.config, 0) {
-			entries = append(entries, entry)
-		}
+	for _, entry := range initial.Entries {
+		entries = append(entries, entry)
 	}
+	m.pages, m.next = 1, initial.Next
 	m.list.SetItems(entries)
 	go m.preloadThumbnails(preloadAmt)
 
@@ -149,10 +167,18 @@ func (m entriesModel) Init() tea.Cmd {
 
 func (m entriesModel) Refresh() (entriesModel, tea.Cmd) {
 	var entries []list.Item
-	for page := 0; page <= m.maxPageOffset; page++ {
-		for _, entry := range m.src(m.config, page) {
-			entries = append(entries, entry)
+	// reload as many pages as were loaded, from the top of the list
+	after := ""
+	for page := 0; page < m.pages; page++ {
+		next := m.src(m.config, m.options, after)
+		for _, entry := range next.Entries {
+			entries = append(entries, m.withContent(entry))
+		}
+		m.next = next.Next
+		if next.Next == "" {
+			break
 		}
+		after = next.Next
 	}
 	cmd := m.list.SetItems(entries)
 
@@ -219,14 +245,72 @@ func (m *entriesModel) SyncColumns() tea.Cmd {
 	m.vp.YOffset = 0
 	m.vp.SetXOffset(0)
 	selected := m.getSelectedEntry()
-	m.vp.SetContent(selected.FullDescription(m.vp.Width))
+	m.vp.SetContent(m.entryContent(selected))
 	cmd := m.drawCurImage()
 	if selected.Thumbnail != "" {
 		m.vp.Height = getPaneHeight(m.height, 1-m.config.ThumbnailRatio)
 	} else {
 		m.vp.Height = getPaneHeight(m.height, 1)
 	}
-	return cmd
+	return tea.Batch(cmd, m.fetchEntry(selected), m.fetchArticle(selected))
+}
+
+// withContent returns the full entry fetched earlier for entry, if it is a
+// summary, with the read and starred state listed.
+func (m entriesModel) withContent(entry list_entry) list_entry {
+	full, ok := m.entries[entry.ID]
+	if !entry.summary || !ok {
+		return entry
+	}
+	full.Read, full.Starred = entry.Read, entry.Starred
+	return full
+}
+
+// fetchEntry fetches the content of entry if it is a summary.
+func (m entriesModel) fetchEntry(entry list_entry) tea.Cmd {
+	if !entry.summary {
+		return nil
+	}
+	config := m.config
+	return func() tea.Msg {
+		full, err := getEntry(config, entry.ID)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		return entryReadyMsg{entry: full}
+	}
+}
+
+// entryContent renders the viewport content for entry: its full article when
+// article view is on and the article has been fetched, its description
+// otherwise.
+func (m entriesModel) entryContent(entry list_entry) string {
+	if m.showArticle {
+		if content, ok := m.articles[entry.primaryLink()]; ok {
+			return entry.renderBody(m.vp.Width, content)
+		}
+	}
+	return entry.FullDescription(m.vp.Width)
+}
+
+func (m entriesModel) fetchArticle(entry list_entry) tea.Cmd {
+	link := entry.primaryLink()
+	if !m.showArticle || link == "" {
+		return nil
+	}
+	if _, ok := m.articles[link]; ok {
+		return nil
+	}
+	config := m.config
+	return func() tea.Msg {
+		content, err := getArticle(config, link)
+		if err != nil {
+			log.Println(err)
+			content = fmt.Sprintf("<p><em>%s</em></p>%s", err, entry.Description_field)
+		}
+		return articleReadyMsg{link: link, content: content}
+	}
 }
 
 func (m entriesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -242,7 +326,7 @@ func (m entriesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.vp.Height = getPaneHeight(m.height, 1-m.config.ThumbnailRatio)
 		m.ready = true
 		selected := m.getSelectedEntry()
-		m.vp.SetContent(selected.FullDescription(m.vp.Width))
+		m.vp.SetContent(m.entryContent(selected))
 
 	case tea.KeyMsg:
 		k := msg.String()
@@ -289,12 +373,37 @@ func (m entriesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return initialListPopupModel(m.config, m.config.getLinkOpener, selected.getLinks,
 				false, m, "Choose which link to open", []string{}, RefreshCmd), tea.WindowSize()
 		}
+		if in(k, m.config.Keys["sortMenu"]) {
+			m.thumbnail.clear()
+			return m.sortMenu(), tea.WindowSize()
+		}
 		if in(k, m.config.Keys["open"]) {
 			if len(selected.Links) >= 1 {
 				defaultLink := selected.Links[0]
 				m.config.getLinkOpener(m.config, []string{defaultLink.URL, defaultLink.Type})
 			}
 		}
+		if in(k, m.config.Keys["fullArticle"]) {
+			m.showArticle = !m.showArticle
+			return m, m.SyncColumns()
+		}
+		if in(k, m.config.Keys["download"]) {
+			if enc := selected.enclosure(); enc.URL != "" {
+				downloads.enqueue(m.config, enc.URL)
+				return m, tea.Batch(m.SyncColumns(), downloadTick())
+			}
+		}
+		if in(k, m.config.Keys["play"]) {
+			if enc := selected.enclosure(); enc.URL != "" {
+				m.thumbnail.clear()
+				cmd, err := playEnclosure(m.config, selected, enc.URL)
+				if err != nil {
+					log.Println(err)
+					return m, nil
+				}
+				return m, cmd
+			}
+		}
 		if in(k, m.config.Keys["copyLink"]) {
 			if len(selected.Links) >= 1 {
 				defaultLink := selected.Links[0]
@@ -326,6 +435,53 @@ func (m entriesModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		newList, cmd := m.list.Update(msg)
 		m.list = newList
 		return m, tea.Batch(cmd, m.SyncColumns())
+	case entryOptionMsg:
+		return m.setOption(msg)
+	case entryReadyMsg:
+		m.entries[msg.entry.ID] = msg.entry
+		if msg.entry.Article != "" {
+			m.articles[msg.entry.primaryLink()] = msg.entry.Article
+		}
+		for idx, item := range m.list.Items() {
+			if entry, ok := item.(list_entry); ok && entry.summary && entry.ID == msg.entry.ID {
+				m.list.SetItem(idx, m.withContent(entry))
+			}
+		}
+		if selected := m.getSelectedEntry(); selected.ID == msg.entry.ID {
+			yOffset := m.vp.YOffset
+			m.vp.SetContent(m.entryContent(selected))
+			m.vp.SetYOffset(yOffset)
+			return m, m.fetchArticle(selected)
+		}
+		return m, nil
+	case articleReadyMsg:
+		m.articles[msg.link] = msg.content
+		if m.getSelectedEntry().primaryLink() == msg.link {
+			yOffset := m.vp.YOffset
+			m.vp.SetContent(m.entryContent(m.getSelectedEntry()))
+			m.vp.SetYOffset(yOffset)
+		}
+		return m, nil
+	case downloadTickMsg:
+		yOffset := m.vp.YOffset
+		m.vp.SetContent(m.entryContent(selected))
+		m.vp.SetYOffset(yOffset)
+		if downloads.active() {
+			return m, downloadTick()
+		}
+		return m, nil
+	case playbackDoneMsg:
+		for idx, item := range m.list.Items() {
+			entry, ok := item.(list_entry)
+			if !ok || entry.enclosure().URL != msg.link || entry.Podcast == nil {
+				continue
+			}
+			podcast := *entry.Podcast
+			podcast.Position = msg.position
+			entry.Podcast = &podcast
+			m.list.SetItem(idx, entry)
+		}
+		return m, tea.Batch(tea.WindowSize(), m.SyncColumns())
 	case thumbnailReadyMsg:
 		if m.getSelectedEntry().Thumbnail == msg.url {
 			return m, m.drawCurImage()
@@ -391,15 +547,16 @@ func (m *entriesModel) drawCurImage() tea.Cmd {
 }
 
 func (m *entriesModel) paginationLogic() tea.Cmd {
-	if m.list.Paginator.OnLastPage() {
+	if m.list.Paginator.OnLastPage() && m.next != "" {
 		current := m.list.Items()
-		nextPage := m.src(m.config, m.maxPageOffset+1)
-		if len(nextPage) == 0 {
+		nextPage := m.src(m.config, m.options, m.next)
+		if len(nextPage.Entries) == 0 {
 			return nil
 		}
-		m.maxPageOffset++
-		for _, entry := range nextPage {
-			current = append(current, entry)
+		m.pages++
+		m.next = nextPage.Next
+		for _, entry := range nextPage.Entries {
+			current = append(current, m.withContent(entry))
 		}
 		return m.list.SetItems(current)
 	}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// entry_options are the sort and filters the entries view lists entries
+// with, set from the sort menu.
+type entry_options struct {
+	// Sort is one of entrySorts, publication time when empty
+	Sort   string
+	Oldest bool
+	// Since and Until are dates or unix times
+	Since      string
+	Until      string
+	Author     string
+	Enclosures bool
+}
+
+var entrySorts = []struct{ key, title string }{
+	{"published", "publication time"},
+	{"seen", "first seen"},
+	{"feed", "feed"},
+	{"title", "title"},
+}
+
+// entryOptionMsg sets an option of the entries view chosen in the sort
+// menu. Options taking a value ask for it first, unless set.
+type entryOptionMsg struct {
+	option string
+	value  string
+	set    bool
+}
+
+// apply adds the options to a /get_entries query.
+func (o entry_options) apply(q url.Values) {
+	if o.Sort != "" {
+		q.Set("sort", o.Sort)
+	}
+	if o.Oldest {
+		q.Set("order", "asc")
+	}
+	if o.Since != "" {
+		q.Set("since", o.Since)
+	}
+	if o.Until != "" {
+		q.Set("until", o.Until)
+	}
+	if o.Author != "" {
+		q.Set("author", o.Author)
+	}
+	if o.Enclosures {
+		q.Set("enclosures", "")
+	}
+}
+
+func (o entry_options) menuItems(FeedieConfig, string) []popUpListItem {
+	items := []popUpListItem{}
+	for _, s := range entrySorts {
+		mark := "  "
+		if o.Sort == s.key || o.Sort == "" && s.key == "published" {
+			mark = "* "
+		}
+		items = append(items, popUpListItem{Title_Field: mark + "Sort by " + s.title, Url: "sort:" + s.key})
+	}
+	order := "newest first"
+	if o.Oldest {
+		order = "oldest first"
+	}
+	enclosures := "off"
+	if o.Enclosures {
+		enclosures = "on"
+	}
+	orAny := func(s string) string {
+		if s == "" {
+			return "any"
+		}
+		return s
+	}
+	return append(items,
+		popUpListItem{Title_Field: "  Order: " + order, Url: "order"},
+		popUpListItem{Title_Field: "  Since: " + orAny(o.Since), Url: "since"},
+		popUpListItem{Title_Field: "  Until: " + orAny(o.Until), Url: "until"},
+		popUpListItem{Title_Field: "  Author: " + orAny(o.Author), Url: "author"},
+		popUpListItem{Title_Field: "  Only with enclosures: " + enclosures, Url: "enclosures"},
+		popUpListItem{Title_Field: "  Clear filters", Url: "clear"},
+	)
+}
+
+func (m entriesModel) sortMenu() tea.Model {
+	var option string
+	choose := func(_ FeedieConfig, values []string) error {
+		if len(values) == 2 {
+			option = values[1]
+		}
+		return nil
+	}
+	end := func(string) tea.Cmd {
+		return func() tea.Msg { return entryOptionMsg{option: option} }
+	}
+	return initialListPopupModel(m.config, choose, m.options.menuItems, false, m,
+		"Sort and filter entries", []string{}, end)
+}
+
+// setOption applies an option chosen in the sort menu and reloads the list.
+func (m entriesModel) setOption(msg entryOptionMsg) (tea.Model, tea.Cmd) {
+	opts := m.options
+	switch msg.option {
+	case "order":
+		opts.Oldest = !opts.Oldest
+	case "enclosures":
+		opts.Enclosures = !opts.Enclosures
+	case "clear":
+		opts = entry_options{Sort: opts.Sort, Oldest: opts.Oldest}
+	case "since", "until", "author":
+		if !msg.set {
+			return m.askOption(msg.option), tea.WindowSize()
+		}
+		switch msg.option {
+		case "since":
+			opts.Since = msg.value
+		case "until":
+			opts.Until = msg.value
+		case "author":
+			opts.Author = msg.value
+		}
+	default:
+		sort, ok := strings.CutPrefix(msg.option, "sort:")
+		if !ok {
+			return m, tea.WindowSize()
+		}
+		opts.Sort = sort
+	}
+	m.options = opts
+	m.pages = 1
+	m.list.ResetSelected()
+	m, cmd := m.Refresh()
+	return m, tea.Batch(cmd, m.SyncColumns(), tea.WindowSize())
+}
+
+// askOption prompts for the value of an option, an empty one clearing it.
+func (m entriesModel) askOption(option string) tea.Model {
+	prompt := "Entries by authors containing (empty for any)"
+	validate := func(FeedieConfig, []string) error { return nil }
+	if option != "author" {
+		prompt = fmt.Sprintf("Entries published %s the date YYYY-MM-DD (empty for any)", option)
+		validate = validEntryTime
+	}
+	end := func(value string) tea.Cmd {
+		return func() tea.Msg { return entryOptionMsg{option: option, value: value, set: true} }
+	}
+	return initialTextPopupModel(m.config, validate, m, prompt, end)
+}
+
+func validEntryTime(_ FeedieConfig, values []string) error {
+	value := values[0]
+	if value == "" {
+		return nil
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return nil
+	}
+	if _, err := time.Parse(time.DateOnly, value); err != nil {
+		return fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	return nil
+}
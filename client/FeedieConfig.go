@@ -74,6 +74,7 @@ func parseConfigFile(path string) FeedieConfig{
  type FeedieConfig struct{
 	 SERVER string `json:"server"` 
 	 PORT string`json:"port"` 
+	 Token string`json:"token"` 
 	 EntryLimit int `json:"entrylimit"`
 	 BorderType string`json:"bordertype"` 
 	 FocusFG string`json:"focusfg"` 
@@ -93,6 +94,9 @@ func parseConfigFile(path string) FeedieConfig{
 	 TypeOpener map[string]string`json:"typeopener"` 
 	 URLOpener map[string]string`json:"urlopener"` 
 	 DefaultOpener string`json:"defaultopener"` 
+	 DownloadPath string`json:"downloadpath"` 
+	 Player string`json:"player"` 
+	 PlayerStartArg string`json:"playerstartarg"` 
 	 Keys map[string][]string`json:"keys"` 
 
  }
@@ -165,8 +169,8 @@ func parseConfigFile(path string) FeedieConfig{
 
 	 return del
  }
- func (fc FeedieConfig) getEntryDelegate() list.ItemDelegate{
-	 del := FeedieEntryDelegate{config: fc}
+ func (fc FeedieConfig) getEntryDelegate(showFeed bool) list.ItemDelegate{
+	 del := FeedieEntryDelegate{config: fc, showFeed: showFeed}
 
 	 del.Styles.SelectedTitle = fc.getSelectedStyle().Bold(true)
 	 del.Styles.SelectedDesc = fc.getSelectedStyle()
@@ -196,44 +200,54 @@ func parseConfigFile(path string) FeedieConfig{
 	 if len(values) < 2{
 		 log.Fatal("odd length of values")
 	 }	
-	 link := FeedieLink{URL: values[0], Type: values[1]}
+	 cmd, err := pfc.linkCommand(FeedieLink{URL: values[0], Type: values[1]})
+	 if err != nil {
+		 return err
+	 }
+	 go cmd.Run()
+	 return nil
+ }
+
+ // linkCommand returns the command opening link with the configured opener.
+ func (fc FeedieConfig) linkCommand(link FeedieLink) (*exec.Cmd, error){
 	 // match by URL first
 	 // key = regex for url to match
 	 // value = program to run
-	 for key, value := range pfc.URLOpener {
+	 for key, value := range fc.URLOpener {
 		 re, err := regexp.Compile(key)
 		 if err != nil {
-			 return err
+			 return nil, err
 		 }
 		 if re.MatchString(link.URL){
 			 cmd := exec.Command(value, link.URL)
 			 cmd.Stdin = nil
-			 go cmd.Run()
-			 return nil
+			 return cmd, nil
 		 }
 	 }
 	 // match by type second
 	 // key = type of url i.e. "text/html"
 	 // value = program to run
-	 for key, value := range pfc.TypeOpener {
+	 for key, value := range fc.TypeOpener {
 		 if link.Type == key{
 			 cmd := exec.Command(value, link.URL)
 			 cmd.Stdin = nil
-			 go cmd.Run()
-			 return nil
+			 return cmd, nil
 		 }
 	 }
 
-	 cmd := exec.Command(pfc.DefaultOpener, link.URL)
+	 cmd := exec.Command(fc.DefaultOpener, link.URL)
 	 cmd.Stdin = nil
-	 go cmd.Run()
-	 return nil
+	 return cmd, nil
 
  }
 
 
 
  func getDefaultConf() FeedieConfig{
+	 downloadPath := "/tmp/feedie-go/downloads"
+	 if home, exists := os.LookupEnv("HOME"); exists{
+		 downloadPath = filepath.Join(home, "Podcasts")
+	 }
 	 fc :=  FeedieConfig{
 		 SERVER: "http://localhost",
 		 PORT: ":2550",
@@ -254,6 +268,9 @@ func parseConfigFile(path string) FeedieConfig{
 		 ThumbnailScaler: "fit_contain",
 		 LinkCopyCommand: "xclip -i -selection clipboard",
 		 DefaultOpener: "xdg-open",
+		 DownloadPath: downloadPath,
+		 Player: "mpv",
+		 PlayerStartArg: "--start=%d",
 		 URLOpener: make(map[string]string),
 		 TypeOpener: make(map[string]string),
 		 Keys: map[string][]string{
@@ -275,6 +292,15 @@ func parseConfigFile(path string) FeedieConfig{
 			 "refresh":{"r"},
 			 "help":{"?"},
 			 "select":{" "},
+			 "addRule":{"f"},
+			 "rules":{"F"},
+			 "share":{"S"},
+			 "fullText":{"X"},
+			 "history":{"H"},
+			 "fullArticle":{"v"},
+			 "download":{"D"},
+			 "play":{"p"},
+			 "sortMenu":{"s"},
 		 },
 	 }
 	 return fc
@@ -7,6 +7,7 @@ import (
 	"golang.org/x/term"
 	"os"
 	"regexp"
+	"strings"
 	"time"
 	"log"
 
@@ -23,6 +24,8 @@ const (
 	actionGraphical FeedieClientAction = iota
 	actionAddFeed
 	actionGetTags
+	actionCommand
+	actionHelp
 )
 
 var configPath string
@@ -51,6 +54,14 @@ func parseArgs() (FeedieClientAction, []string){
 			args = append(args, os.Args[i+1]) 
 		case "--get_tags":
 			target = actionGetTags
+		case "-h", "--help", "help":
+			return actionHelp, args
+		default:
+			// the first word that isn't a flag or its value names a command
+			if i > 0 && !strings.HasPrefix(arg, "-") && target == actionGraphical &&
+				!in(os.Args[i-1], []string{"-c", "--config", "--add_feed", "--tag"}) {
+				return actionCommand, os.Args[i:]
+			}
 		}
 	}
 	return target, args
@@ -59,6 +70,7 @@ func parseArgs() (FeedieClientAction, []string){
 func main() {
 	action, args := parseArgs()
 	config := parseConfigFile(configPath)
+	configureAuth(config)
 	switch action {
 	case actionGraphical:
 		_ = args
@@ -87,6 +99,12 @@ func main() {
 			aFArgs = append(aFArgs, feed)
 			getActionFunc(modTagMember_t)(config, aFArgs)
 		}
+	case actionGetTags:
+		os.Exit(runCommand(config, []string{"tags"}))
+	case actionCommand:
+		os.Exit(runCommand(config, args))
+	case actionHelp:
+		commandUsage(os.Stdout)
 	}
 }
 
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// mpv reports where playback is over its JSON IPC socket, so the position
+// saved after it exits is where the listener stopped, pauses not counted.
+// Other players only tell how long they ran for.
+
+const playerPollRate = time.Second
+
+// playerPosition polls the playback position of an mpv instance listening
+// on socket until stop is closed.
+type playerPosition struct {
+	socket string
+	stop   chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+	pos    float64
+	known  bool
+}
+
+// mpvIPCSocket returns a socket path for an mpv instance to listen on.
+func mpvIPCSocket() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("feedie-mpv-%d.sock", os.Getpid()))
+}
+
+func watchPlayerPosition(socket string) *playerPosition {
+	p := &playerPosition{socket: socket, stop: make(chan struct{}), done: make(chan struct{})}
+	go p.poll()
+	return p
+}
+
+func (p *playerPosition) poll() {
+	defer close(p.done)
+	t := time.NewTicker(playerPollRate)
+	defer t.Stop()
+	for {
+		if pos, err := mpvTimePos(p.socket); err == nil {
+			p.mu.Lock()
+			p.pos, p.known = pos, true
+			p.mu.Unlock()
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// position stops polling and returns the last position read, if any.
+func (p *playerPosition) position() (int64, bool) {
+	close(p.stop)
+	<-p.done
+	os.Remove(p.socket)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int64(p.pos), p.known
+}
+
+// mpvTimePos asks mpv for its time-pos property.
+func mpvTimePos(socket string) (float64, error) {
+	conn, err := net.DialTimeout("unix", socket, playerPollRate)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(playerPollRate))
+	if _, err := fmt.Fprintln(conn, `{"command": ["get_property", "time-pos"], "request_id": 1}`); err != nil {
+		return 0, err
+	}
+	// events may come before the reply
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var reply struct {
+			Data      *float64 `json:"data"`
+			Error     string   `json:"error"`
+			RequestID int      `json:"request_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil || reply.RequestID != 1 {
+			continue
+		}
+		if reply.Error != "success" || reply.Data == nil {
+			return 0, fmt.Errorf("mpv: %s", reply.Error)
+		}
+		return *reply.Data, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("mpv closed the connection")
+}
@@ -30,7 +30,7 @@ func (m selectModel) getSelectedSource() list_source {
 }
 
 var preloadMu sync.Mutex
-var preloadMap map[string][]list_entry
+var preloadMap map[string]entry_page
 
 func initialSelectModel(optFunc func(FeedieConfig) []list_source, config FeedieConfig) selectModel {
 	m := selectModel{
@@ -93,7 +93,7 @@ func initialSelectModel(optFunc func(FeedieConfig) []list_source, config FeedieC
 	}
 
 	m.list.SetItems(sources)
-	preloadMap = make(map[string][]list_entry)
+	preloadMap = make(map[string]entry_page)
 	m.preloadFeeds(preloadAmt)
 
 	return m
@@ -123,7 +123,7 @@ func (m selectModel) preloadFeeds(preload int) {
 			continue
 		}
 		go func(s list_source) {
-			entries := s.SrcFunc(m.config, 0)
+			entries := s.SrcFunc(m.config, entry_options{}, "")
 			preloadMu.Lock()
 			preloadMap[s.Url] = entries
 			preloadMu.Unlock()
@@ -131,14 +131,14 @@ func (m selectModel) preloadFeeds(preload int) {
 	}
 }
 
-func (m selectModel) getPreloaded(url string) []list_entry {
+func (m selectModel) getPreloaded(url string) entry_page {
 	preloadMu.Lock()
 	v, ok := preloadMap[url]
 	preloadMu.Unlock()
 	if ok {
 		return v
 	}
-	return []list_entry{}
+	return entry_page{}
 }
 
 func (m selectModel) Init() tea.Cmd {
@@ -205,7 +205,7 @@ func (m selectModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if in(k, m.config.Keys["open"]) {
 			selected := m.getSelectedSource()
 			return initialEntriesModel(selected.SrcFunc, m.config, m,
-				m.getPreloaded(selected.Url)),
+				m.getPreloaded(selected.Url), selected.SrcType != Feed),
 				tea.WindowSize()
 		}
 
@@ -228,6 +228,50 @@ func (m selectModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		if in(k, m.config.Keys["addRule"]) {
+			return initialTextPopupModel(m.config, getAddRuleAction(m.getSelectedSource()), m,
+				"Enter rule (<action>[:<tag>] <field> <pattern>):", RefreshCmd), tea.WindowSize()
+		}
+
+		if in(k, m.config.Keys["rules"]) {
+			rules := func(config FeedieConfig, _ string) []popUpListItem { return getRuleOptions(config) }
+			return initialListPopupModel(m.config, getActionFunc(delRule_t), rules, false, m,
+				"Rules (enter to delete):", []string{}, RefreshCmd), tea.WindowSize()
+		}
+
+		if in(k, m.config.Keys["share"]) {
+			selected := m.getSelectedSource()
+			if selected.SrcType == Tag && selected.Url != "" {
+				prompt := fmt.Sprintf("Publish tag %s as a public feed?", selected.Title_field)
+				if selected.Public {
+					prompt = fmt.Sprintf("Stop publishing tag %s?", selected.Title_field)
+				}
+				return initialConfirmPopupModel(m.config, getActionFunc(setTagPublic_t), m, prompt,
+					[]string{selected.Title_field, fmt.Sprint(!selected.Public)}, RefreshCmd), tea.WindowSize()
+			}
+		}
+
+		if in(k, m.config.Keys["fullText"]) {
+			selected := m.getSelectedSource()
+			if selected.SrcType == Feed {
+				prompt := fmt.Sprintf("Always fetch full articles for %s?", selected.Title_field)
+				if selected.FullText {
+					prompt = fmt.Sprintf("Stop fetching full articles for %s?", selected.Title_field)
+				}
+				return initialConfirmPopupModel(m.config, getActionFunc(setFeedFullText_t), m, prompt,
+					[]string{selected.Url, fmt.Sprint(!selected.FullText)}, RefreshCmd), tea.WindowSize()
+			}
+		}
+
+		if in(k, m.config.Keys["history"]) {
+			selected := m.getSelectedSource()
+			if selected.SrcType == Feed {
+				noAction := func(FeedieConfig, []string) error { return nil }
+				return initialListPopupModel(m.config, noAction, getFeedHistoryOptions, false, m,
+					fmt.Sprintf("History of %s:", selected.Title_field), []string{selected.Url}, RefreshCmd), tea.WindowSize()
+			}
+		}
+
 		if in(k, m.config.Keys["delete"]) {
 			selected := m.getSelectedSource()
 			if selected.SrcType == Feed {
@@ -310,7 +354,7 @@ func (m *selectModel) clearPreload() {
 }
 
 func getSelectKeys(config FeedieConfig) func() []key.Binding {
-	selectCommands := []string{"addFeed", "addTag", "delete", "modTag", "refresh"}
+	selectCommands := []string{"addFeed", "addTag", "delete", "modTag", "refresh", "addRule", "rules", "share", "fullText", "history"}
 	ret := []key.Binding{}
 	for command, keys := range config.Keys {
 		if in(command, selectCommands) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"io"
 	"slices"
 	"time"
@@ -52,27 +53,92 @@ type FeedieLink struct {
 	Type string
 }
 type list_entry struct{
+	ID int64 `json:"ID"`
 	Title_field string `json:"Title"`
 	Author string `json:"Author"`
 	Thumbnail string `json:"Thumbnail"`
 	Description_field string `json:"Description"`
 	Published int `json:"Published"`
 	Links []FeedieLink `json:"Links"`
+	Read bool `json:"Read"`
+	Starred bool `json:"Starred"`
+	Podcast *podcastInfo `json:"Podcast"`
+	Video *videoInfo `json:"Video"`
+	FeedID int64 `json:"FeedID"`
+	FeedTitle string `json:"FeedTitle"`
+	FaviconURL string `json:"FaviconURL"`
+	Article string `json:"Article,omitempty"`
+	// summaries from getEntrySummaries have no description or links,
+	// only a plain text excerpt
+	LinkCount int `json:"LinkCount,omitempty"`
+	Excerpt string `json:"Excerpt,omitempty"`
+	summary bool
+}
+
+type videoInfo struct{
+	Views int64
+	RatingAverage float64
+	RatingCount int64
+	Duration int64
+}
+
+type podcastChapter struct{
+	Start int64
+	Title string
+}
+
+type podcastInfo struct{
+	Duration int64
+	Size int64
+	Episode int
+	Season int
+	ChaptersURL string
+	Chapters []podcastChapter
+	Position int64
+}
+func (i list_entry) Title() string       {
+	if i.Starred{
+		return "★ " + stripZWC(i.Title_field)
+	}
+	return stripZWC(i.Title_field)
 }
-func (i list_entry) Title() string       { return stripZWC(i.Title_field)}
 func (i list_entry) Description() string { return stripZWC(i.Author)}
+// SourceDescription is the Description preceded by the feed the entry is
+// from, for views mixing entries of several feeds.
+func (i list_entry) SourceDescription() string {
+	feed := stripZWC(i.FeedTitle)
+	// feeds without authors list their own title
+	if feed == "" || feed == i.Description(){
+		return i.Description()
+	}
+	if i.Description() == ""{
+		return feed
+	}
+	return feed + " · " + i.Description()
+}
 func (i list_entry) FilterValue() string { return i.Title_field }
 
 func (i list_entry) FullDescription(Width int) string{
+	if i.summary{
+		return i.renderBody(Width, "<p>"+html.EscapeString(i.Excerpt)+"</p>")
+	}
+	return i.renderBody(Width, i.Description_field)
+}
+
+// renderBody renders the entry header followed by body, an html document
+// such as the description or the extracted article.
+func (i list_entry) renderBody(Width int, body string) string{
 	base := ""
 	base += fmt.Sprintf("%s\n %s\n",
 		lipgloss.NewStyle().Bold(true).Render(i.Title_field),
 	lipgloss.NewStyle().Faint(true).Render(i.published()))
+	base += i.videoHeader()
+	base += i.podcastHeader()
 	base+= strings.Repeat("-", Width)
 	base+= "\n"
-	md, err := htmltomarkdown.ConvertString(i.Description_field)
+	md, err := htmltomarkdown.ConvertString(body)
 	if err != nil{
-		md = i.Description_field
+		md = body
 	}
 	base += md
 
@@ -80,6 +146,120 @@ func (i list_entry) FullDescription(Width int) string{
 	
 }
 
+// videoHeader renders the statistics of video entries, shown under the
+// thumbnail.
+func (i list_entry) videoHeader() string{
+	v := i.Video
+	if v == nil{
+		return ""
+	}
+	info := []string{}
+	if v.Duration > 0{
+		info = append(info, formatDuration(v.Duration))
+	}
+	if v.Views > 0{
+		info = append(info, fmt.Sprintf("%s views", formatCount(v.Views)))
+	}
+	if v.RatingCount > 0{
+		info = append(info, fmt.Sprintf("★ %.1f (%s ratings)", v.RatingAverage, formatCount(v.RatingCount)))
+	}
+	if len(info) == 0{
+		return ""
+	}
+	return fmt.Sprintf(" %s\n", lipgloss.NewStyle().Faint(true).Render(strings.Join(info, " · ")))
+}
+
+// formatCount abbreviates large counts the way video sites do, e.g. 1.2M.
+func formatCount(n int64) string{
+	switch{
+	case n >= 1_000_000_000:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// podcastHeader renders the episode metadata, playback position and
+// download state of podcast entries.
+func (i list_entry) podcastHeader() string{
+	p := i.Podcast
+	if p == nil{
+		return ""
+	}
+	faint := lipgloss.NewStyle().Faint(true)
+	info := []string{}
+	if p.Season > 0 && p.Episode > 0{
+		info = append(info, fmt.Sprintf("S%dE%d", p.Season, p.Episode))
+	} else if p.Episode > 0{
+		info = append(info, fmt.Sprintf("Episode %d", p.Episode))
+	}
+	if p.Duration > 0{
+		info = append(info, formatDuration(p.Duration))
+	}
+	if p.Size > 0{
+		info = append(info, formatSize(p.Size))
+	}
+	base := ""
+	if len(info) > 0{
+		base += fmt.Sprintf(" %s\n", faint.Render(strings.Join(info, " · ")))
+	}
+	if p.Position > 0{
+		base += fmt.Sprintf(" %s\n", faint.Render("Resume at " + formatDuration(p.Position)))
+	}
+	if status := downloads.status(i.enclosure().URL); status != ""{
+		base += fmt.Sprintf(" %s\n", faint.Render(status))
+	}
+	if summary := downloads.summary(); summary != ""{
+		base += fmt.Sprintf(" %s\n", faint.Render(summary))
+	}
+	for _, c := range p.Chapters{
+		base += fmt.Sprintf(" %s %s\n", faint.Render(formatDuration(c.Start)), c.Title)
+	}
+	if p.ChaptersURL != ""{
+		base += fmt.Sprintf(" %s\n", faint.Render("Chapters: " + p.ChaptersURL))
+	}
+	return base
+}
+
+// enclosure returns the entry's first audio or video link.
+func (i list_entry) enclosure() FeedieLink{
+	for _, link := range i.Links{
+		if strings.HasPrefix(link.Type, "audio/") || strings.HasPrefix(link.Type, "video/"){
+			return link
+		}
+	}
+	return FeedieLink{}
+}
+
+// primaryLink returns the entry's first web page link, the one full
+// articles are extracted from.
+func (i list_entry) primaryLink() string{
+	for _, link := range i.Links{
+		if link.Type == "text/html"{
+			return link.URL
+		}
+	}
+	return ""
+}
+
+// link returns the link to open for the entry: its web page, else its
+// enclosure, else whatever link it has.
+func (i list_entry) link() string{
+	if l := i.primaryLink(); l != ""{
+		return l
+	}
+	if l := i.enclosure().URL; l != ""{
+		return l
+	}
+	if len(i.Links) > 0{
+		return i.Links[0].URL
+	}
+	return ""
+}
+
 func (i list_entry) published () string{
 	return time.Unix(int64(i.Published), 0).Format(time.RFC1123)
 }
@@ -102,6 +282,8 @@ type FeedieSelectDelegate struct{
 type FeedieEntryDelegate struct{
 	list.DefaultDelegate
 	config FeedieConfig
+	// name the feed of each entry, in the All feeds and tag views
+	showFeed bool
 }
 func (d FeedieEntryDelegate) Height() int {return 2}
 func (d FeedieEntryDelegate) Spacing() int {return 1}
@@ -111,6 +293,9 @@ func (d FeedieEntryDelegate) Render(w io.Writer, m list.Model, index int, listIt
 		bar := " " // remove the bar (or set to "▶ " or "→ ")
 		title := i.Title()
 		desc := i.Description()
+		if d.showFeed{
+			desc = i.SourceDescription()
+		}
 
 		if index == m.Index() {
 			bar = "┃" // custom indicator
@@ -119,7 +304,7 @@ func (d FeedieEntryDelegate) Render(w io.Writer, m list.Model, index int, listIt
 			desc = d.Styles.SelectedDesc.MaxWidth(m.Width()-2).MaxHeight(1).Render(desc)
 		}else{
 			bar = " " // custom indicator
-			title = d.Styles.NormalTitle.MaxWidth(m.Width() - 2).MaxHeight(1).Render(title)
+			title = d.Styles.NormalTitle.Faint(i.Read).MaxWidth(m.Width() - 2).MaxHeight(1).Render(title)
 			bar = d.Styles.NormalTitle.Foreground(lipgloss.Color(d.config.SelectCursor)).Render(bar)
 			desc = d.Styles.NormalDesc.MaxWidth(m.Width()-2).MaxHeight(1).Render(desc)
 		}
@@ -162,11 +347,22 @@ const (
 
 )
 
+// entry_page is a page of entries from /get_entries, with the cursors to
+// the pages after and before it.
+type entry_page struct {
+	Entries []list_entry
+	Next    string
+	Prev    string
+}
+
 type list_source struct{
 	Title_field string `json:"Title"`
 	SrcType SourceType `json:"SrcType"`
-	SrcFunc func(FeedieConfig, int) []list_entry 
+	SrcFunc func(FeedieConfig, entry_options, string) entry_page 
 	Url string `json:"Url"`
+	Public bool `json:"Public"`
+	FullText bool `json:"FullText"`
+	Dead bool `json:"Dead"`
 }
 func (i list_source) Title() string       { 
 	var icon string
@@ -176,6 +372,12 @@ func (i list_source) Title() string       {
 	default:
 		icon = ""
 	}
+	if i.Public{
+		return fmt.Sprintf("%s%s (public)",icon,stripZWC(i.Title_field))
+	}
+	if i.Dead{
+		return fmt.Sprintf("%s%s (gone)",icon,stripZWC(i.Title_field))
+	}
 	return fmt.Sprintf("%s%s",icon,stripZWC(i.Title_field)) 
 }
 func (i list_source) Description() string { return "" }
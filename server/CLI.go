@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// feedie-server subcommands. Each one works directly on the database, so
+// they can be run from cron or scripts whether or not the server is running.
+
+type command struct {
+	name    string
+	args    string // argument synopsis for the usage line
+	summary string
+	// flags registers the command's flags and returns the function running it
+	flags func(fs *flag.FlagSet) func(args []string) error
+	// how the database is opened before the command runs
+	db dbAccess
+}
+
+type dbAccess int
+
+const (
+	noDB dbAccess = iota
+	// the database is created or migrated if needed
+	readWriteDB
+	// the database is only read, and left as it is
+	readOnlyDB
+)
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "Run the API server and refresh feeds (the default)", serveFlags, readWriteDB},
+		{"migrate", "[name]", "Update the database schema, or run a one-shot migration", migrateFlags, readWriteDB},
+		{"add-feed", "<url>", "Add a feed and fetch its entries", addFeedFlags, readWriteDB},
+		{"list-feeds", "", "List feeds", listFeedsFlags, readWriteDB},
+		{"refresh", "[feed url...]", "Refresh all feeds or the given ones now", refreshFlags, readWriteDB},
+		{"export", "", "Export feeds and their tags as OPML", exportFlags, readWriteDB},
+		{"import", "<file>", "Import feeds and tags from OPML", importFlags, readWriteDB},
+		{"vacuum", "", "Apply retention and compact the database", vacuumFlags, readWriteDB},
+		{"check", "", "Check the database for corruption", checkFlags, readOnlyDB},
+		{"config", "print", "Print the effective configuration", configFlags, noDB},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: feedie-server [command] [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun 'feedie-server <command> --help' for the flags of a command.\n")
+}
+
+// runCLI runs the command named by args[0], serve if there is none, and
+// returns the exit status.
+func runCLI(args []string) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help" || args[0] == "help") {
+		usage(os.Stdout)
+		return 0
+	}
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	// names of the migrations from before subcommands existed
+	if name == "migrate_add_link_id" || name == "migrate_dedup_guid" {
+		name, args = "migrate", []string{strings.TrimPrefix(name, "migrate_")}
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		run := c.flags(fs)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "Usage: feedie-server %s [flags] %s\n\n%s\n", c.name, c.args, c.summary)
+			hasFlags := false
+			fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+			if hasFlags {
+				fmt.Fprintf(fs.Output(), "\nFlags:\n")
+				fs.PrintDefaults()
+			}
+		}
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			return 2
+		}
+		feedieInit()
+		if c.db != noDB {
+			setupLogging(feedieServer.config)
+			configureHTTP(feedieServer.config)
+		}
+		switch c.db {
+		case readWriteDB:
+			DBInit(feedieServer.config.DBPath)
+		case readOnlyDB:
+			if err := DBOpenReadOnly(feedieServer.config.DBPath); err != nil {
+				fmt.Fprintf(os.Stderr, "feedie-server %s: %v\n", c.name, err)
+				return 1
+			}
+		}
+		err := run(fs.Args())
+		if c.db != noDB {
+			shutDownDB()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "feedie-server %s: %v\n", c.name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "feedie-server: unknown command %q\n\n", name)
+	usage(os.Stderr)
+	return 2
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+func serveFlags(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		conf := feedieServer.config
+		ln, err := net.Listen("tcp", net.JoinHostPort(conf.Bind, strconv.Itoa(conf.Port)))
+		if err != nil {
+			return err
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return serve(ctx, conf, ln)
+	}
+}
+
+var migrations = map[string]func(){
+	"add_link_id": migrateAddLinkID,
+	"dedup_guid":  migrateDedupGUID,
+	"split_guid":  migrateSplitGUID,
+}
+
+func migrateFlags(fs *flag.FlagSet) func([]string) error {
+	list := fs.Bool("list", false, "list the one-shot migrations")
+	return func(args []string) error {
+		if *list {
+			names := []string{}
+			for name := range migrations {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Println(strings.Join(names, "\n"))
+			return nil
+		}
+		// opening the database already brought the schema up to date
+		if len(args) == 0 {
+			fmt.Println("Database schema is up to date")
+			return nil
+		}
+		for _, name := range args {
+			migrate, ok := migrations[name]
+			if !ok {
+				return fmt.Errorf("unknown migration %q, see --list", name)
+			}
+			migrate()
+			fmt.Printf("Ran migration %s\n", name)
+		}
+		return nil
+	}
+}
+
+func addFeedFlags(fs *flag.FlagSet) func([]string) error {
+	tag := fs.String("tag", "", "add the feed to this tag")
+	adapter := fs.String("adapter", "", "source adapter: rss, html, json or command")
+	config := fs.String("config", "", "JSON config of the source adapter")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected one feed url")
+		}
+		src := FeedieSource{Url: args[0], Adapter: *adapter}
+		if *config != "" {
+			if !json.Valid([]byte(*config)) {
+				return errors.New("--config is not valid JSON")
+			}
+			src.Config = json.RawMessage(*config)
+		}
+		feed, err := addFeed(src)
+		if err != nil {
+			return err
+		}
+		if *tag != "" {
+			DBAddTag(*tag)
+			DBAddMembership(*tag, feed.Url)
+		}
+		webhooksInFlight.Wait()
+		fmt.Printf("Added %s (%s), %d entries\n", feed.Title, feed.Url, len(feed.Entries))
+		return nil
+	}
+}
+
+func listFeedsFlags(fs *flag.FlagSet) func([]string) error {
+	tag := fs.String("tag", "", "only list feeds in this tag")
+	asJSON := fs.Bool("json", false, "print JSON")
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		var feeds []FeedieFeed
+		if *tag != "" {
+			feeds = DBGetFeedsByTag(*tag, false)
+		} else {
+			feeds = DBGetFeeds(false)
+		}
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(feeds)
+		}
+		for _, f := range feeds {
+			fmt.Printf("%s\t%s\n", f.Title, f.Url)
+		}
+		return nil
+	}
+}
+
+func refreshFlags(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		urls := args
+		if len(urls) == 0 {
+			if n := DBPurgeEntries(feedieServer.config.Retention); n > 0 {
+				slog.Info("deleted entries past retention", "count", n)
+			}
+			for _, f := range DBGetFeeds(false) {
+				if !f.Dead && !isMailFeed(f.Url) {
+					urls = append(urls, f.Url)
+				}
+			}
+		} else {
+			// refreshing would subscribe to urls that aren't feeds yet
+			unknown := []string{}
+			for _, u := range urls {
+				if !DBFeedExists(u) {
+					unknown = append(unknown, u)
+				}
+			}
+			if len(unknown) > 0 {
+				return fmt.Errorf("not a feed, use add-feed to subscribe:\n  %s", strings.Join(unknown, "\n  "))
+			}
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		failed := []string{}
+		workers := make(chan struct{}, feedieServer.config.RefreshWorkers)
+		for _, u := range urls {
+			wg.Add(1)
+			workers <- struct{}{}
+			go func() {
+				defer func() { <-workers; wg.Done() }()
+				if !refreshFeed(u) {
+					mu.Lock()
+					failed = append(failed, u)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		webhooksInFlight.Wait()
+		fmt.Printf("Refreshed %d of %d feeds\n", len(urls)-len(failed), len(urls))
+		if len(failed) > 0 {
+			return fmt.Errorf("unable to refresh:\n  %s", strings.Join(failed, "\n  "))
+		}
+		return nil
+	}
+}
+
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Created string        `xml:"head>dateCreated,omitempty"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+// feeds using another adapter than rss carry it in feedieAdapter and
+// feedieConfig so an export can be imported back
+type opmlOutline struct {
+	Text          string        `xml:"text,attr"`
+	Title         string        `xml:"title,attr,omitempty"`
+	Type          string        `xml:"type,attr,omitempty"`
+	XMLURL        string        `xml:"xmlUrl,attr,omitempty"`
+	FeedieAdapter string        `xml:"feedieAdapter,attr,omitempty"`
+	FeedieConfig  string        `xml:"feedieConfig,attr,omitempty"`
+	Outlines      []opmlOutline `xml:"outline"`
+}
+
+func feedOutline(f FeedieFeed) opmlOutline {
+	o := opmlOutline{Text: f.Title, Title: f.Title, Type: "rss", XMLURL: f.Url}
+	if src := DBGetFeedSource(f.Url); src.Adapter != defaultAdapter {
+		o.FeedieAdapter, o.FeedieConfig = src.Adapter, string(src.Config)
+	}
+	return o
+}
+
+func exportFlags(fs *flag.FlagSet) func([]string) error {
+	out := fs.String("o", "", "write to this file instead of stdout")
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		doc := opmlDoc{Version: "2.0", Title: "Feedie feeds", Created: time.Now().Format(time.RFC1123Z)}
+		tagged := map[string]bool{}
+		for _, tag := range DBGetTags() {
+			o := opmlOutline{Text: tag, Title: tag}
+			for _, f := range DBGetFeedsByTag(tag, false) {
+				o.Outlines = append(o.Outlines, feedOutline(f))
+				tagged[f.Url] = true
+			}
+			doc.Body = append(doc.Body, o)
+		}
+		for _, f := range DBGetFeeds(false) {
+			if !tagged[f.Url] {
+				doc.Body = append(doc.Body, feedOutline(f))
+			}
+		}
+
+		w := io.Writer(os.Stdout)
+		if *out != "" {
+			file, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		io.WriteString(w, xml.Header)
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+}
+
+func importFlags(fs *flag.FlagSet) func([]string) error {
+	noFetch := fs.Bool("no-fetch", false, "only add the feeds, their entries are fetched on the next refresh")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected one OPML file")
+		}
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		var doc opmlDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+
+		added, failed := 0, []string{}
+		var walk func(outlines []opmlOutline, tag string)
+		walk = func(outlines []opmlOutline, tag string) {
+			for _, o := range outlines {
+				if o.XMLURL == "" {
+					// outlines without a feed are folders, imported as tags
+					name := o.Text
+					if name == "" {
+						name = o.Title
+					}
+					walk(o.Outlines, name)
+					continue
+				}
+				src := FeedieSource{Url: o.XMLURL, Adapter: o.FeedieAdapter}
+				if o.FeedieConfig != "" {
+					src.Config = json.RawMessage(o.FeedieConfig)
+				}
+				feedURL := o.XMLURL
+				if *noFetch {
+					title := o.Title
+					if title == "" {
+						title = o.Text
+					}
+					DBAddFeed(FeedieFeed{Title: title, Url: feedURL})
+					DBSetFeedSource(src)
+				} else {
+					feed, err := addFeed(src)
+					if err != nil {
+						failed = append(failed, fmt.Sprintf("%s: %v", o.XMLURL, err))
+						continue
+					}
+					feedURL = feed.Url
+				}
+				if tag != "" {
+					DBAddTag(tag)
+					DBAddMembership(tag, feedURL)
+				}
+				added++
+			}
+		}
+		walk(doc.Body, "")
+		webhooksInFlight.Wait()
+		fmt.Printf("Imported %d feeds\n", added)
+		if len(failed) > 0 {
+			return fmt.Errorf("unable to import:\n  %s", strings.Join(failed, "\n  "))
+		}
+		return nil
+	}
+}
+
+func vacuumFlags(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		if n := DBPurgeEntries(feedieServer.config.Retention); n > 0 {
+			fmt.Printf("Deleted %d entries past retention\n", n)
+		}
+		DBVacuum()
+		fmt.Println("Vacuumed database")
+		return nil
+	}
+}
+
+func checkFlags(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if err := noArgs(args); err != nil {
+			return err
+		}
+		problems := DBCheck()
+		if len(problems) > 0 {
+			return fmt.Errorf("database has problems:\n  %s", strings.Join(problems, "\n  "))
+		}
+		fmt.Println("ok")
+		return nil
+	}
+}
+
+func configFlags(fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 1 || args[0] != "print" {
+			return errors.New("expected 'config print'")
+		}
+		return printServerConfig(os.Stdout, feedieServer.config)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Clients ask for the same entry lists over and over, the client preloading
+// the lists of the sources around its cursor, so /get_entries responses are
+// kept until a write changes what entries lists hold. Each one is sent with
+// an ETag of its content, so clients can revalidate what they have without
+// the entries being sent again. Subcommands run from cron write from another
+// process, so lookups also check the database's data_version, which changes
+// whenever another connection commits.
+
+// entryCacheSize bounds the number of responses kept.
+const entryCacheSize = 256
+
+type cachedEntries struct {
+	body []byte
+	etag string
+}
+
+type entryResponseCache struct {
+	mu sync.Mutex
+	// generation counts invalidations, so responses read before one don't
+	// get stored after it
+	generation uint64
+	responses  map[string]cachedEntries
+	// version is a connection of its own to read data_version with, the
+	// value being per connection
+	version     *sql.Conn
+	dataVersion int64
+}
+
+var entryCache = entryResponseCache{responses: make(map[string]cachedEntries)}
+
+// invalidateEntryCache drops the cached responses. Writes changing entries,
+// their state or which tags and feeds they're listed in call it once their
+// changes are committed.
+func invalidateEntryCache() {
+	entryCache.mu.Lock()
+	defer entryCache.mu.Unlock()
+	entryCache.generation++
+	clear(entryCache.responses)
+}
+
+// watchDatabase drops the cached responses of the database opened before
+// and checks the data_version of database, if not nil, on lookups. It is
+// called with dbMu held.
+func watchDatabase(database *sql.DB) {
+	entryCache.mu.Lock()
+	defer entryCache.mu.Unlock()
+	entryCache.generation++
+	clear(entryCache.responses)
+	if entryCache.version != nil {
+		entryCache.version.Close()
+		entryCache.version = nil
+	}
+	if database == nil {
+		return
+	}
+	conn, err := database.Conn(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	entryCache.version = conn
+	entryCache.dataVersion = entryCache.readDataVersion()
+}
+
+// readDataVersion returns the database's data_version, or -1 if it can't be
+// read, which keeps nothing cached.
+func (c *entryResponseCache) readDataVersion() int64 {
+	if c.version == nil {
+		return -1
+	}
+	var version int64
+	if err := c.version.QueryRowContext(context.Background(), `PRAGMA data_version`).Scan(&version); err != nil {
+		slog.Warn("unable to read the database's data_version", "err", err)
+		return -1
+	}
+	return version
+}
+
+// get returns the response cached for key, and the generation to store it
+// with when there is none.
+func (c *entryResponseCache) get(key string) (cachedEntries, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// written to by another process
+	if version := c.readDataVersion(); version != c.dataVersion || version == -1 {
+		c.dataVersion = version
+		c.generation++
+		clear(c.responses)
+	}
+	cached, ok := c.responses[key]
+	result := "miss"
+	if ok {
+		result = "hit"
+	}
+	entryCacheLookups.WithLabelValues(result).Inc()
+	return cached, c.generation, ok
+}
+
+// put encodes response and caches it for key, unless the cache was
+// invalidated since generation.
+func (c *entryResponseCache) put(key string, generation uint64, response any) (cachedEntries, error) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(response); err != nil {
+		return cachedEntries{}, err
+	}
+	cached := cachedEntries{body: body.Bytes(), etag: `"` + GetHashString(body.String()) + `"`}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		if len(c.responses) >= entryCacheSize {
+			for k := range c.responses {
+				delete(c.responses, k)
+				break
+			}
+		}
+		c.responses[key] = cached
+	}
+	return cached, nil
+}
+
+// entryCacheKey identifies the response to an entry query.
+func entryCacheKey(method, value string, order timeOrder, page entryPage, paged, summary bool) string {
+	cursor := ""
+	if page.Cursor != nil {
+		cursor = page.Cursor.String()
+	}
+	return strings.Join([]string{method, value,
+		fmt.Sprint(order, page.Limit, page.Offset, page.Backward, paged, summary),
+		cursor, page.sortName(), fmt.Sprintf("%+v", page.Filter)}, "\x00")
+}
+
+// etagMatches reports whether an If-None-Match header lists etag.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
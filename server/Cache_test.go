@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// getEntries requests /get_entries?query, revalidating etag when set.
+func getEntries(query, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/get_entries?"+query, nil)
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	getEntriesHandler(rec, r)
+	return rec
+}
+
+func TestEntryCacheRevalidation(t *testing.T) {
+	initTestDB(t)
+	a := numberedFeed("a.example.com", 1000, 2)
+	DBAddFeedWithEntries(a)
+	DBAddTag("news")
+
+	rec := getEntries("method=all&summary", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("answered %d with ETag %q", rec.Code, etag)
+	}
+	if rec = getEntries("method=all&summary", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("revalidating answered %d with %q", rec.Code, rec.Body)
+	}
+	// another query of the same entries is another response
+	if rec = getEntries("method=all", etag); rec.Code != http.StatusOK {
+		t.Errorf("full entries revalidated against the summaries")
+	}
+
+	changes := map[string]func(){
+		"marking an entry read": func() { DBSetRead(DBGetEntryIDByLink("http://a.example.com/1"), true) },
+		"refreshing a feed":     func() { DBAddFeedWithEntries(numberedFeed("a.example.com", 1000, 3)) },
+		"adding a feed":         func() { DBAddFeedWithEntries(numberedFeed("b.example.com", 1000, 1)) },
+	}
+	for change, apply := range changes {
+		etag = getEntries("method=all&summary", "").Header().Get("ETag")
+		apply()
+		if rec = getEntries("method=all&summary", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Errorf("after %s answered %d with the same ETag", change, rec.Code)
+		}
+	}
+
+	tagged := func() int {
+		var page []FeedieEntrySummary
+		if err := json.NewDecoder(getEntries("method=by_tag&value=news&summary", "").Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return len(page)
+	}
+	if n := tagged(); n != 0 {
+		t.Fatalf("%d entries in the empty tag", n)
+	}
+	DBAddMembership("news", a.Url)
+	if n := tagged(); n != 3 {
+		t.Errorf("%d entries in the tag after adding its feed, want 3", n)
+	}
+	DBDelMembership("news", a.Url)
+	if n := tagged(); n != 0 {
+		t.Errorf("%d entries in the tag after removing its feed, want 0", n)
+	}
+
+	// a response read before a write isn't kept after it
+	key := entryCacheKey("all", "", DESC, allEntries, false, false)
+	_, generation, _ := entryCache.get(key)
+	invalidateEntryCache()
+	entryCache.put(key, generation, []FeedieEntry{})
+	if _, _, ok := entryCache.get(key); ok {
+		t.Error("stale response cached")
+	}
+}
+
+func TestEntryCacheSeesOtherProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedie.db")
+	DBInit(path)
+	t.Cleanup(func() { db.Close() })
+	DBAddFeedWithEntries(numberedFeed("a.example.com", 1000, 2))
+	etag := getEntries("method=all&summary", "").Header().Get("ETag")
+
+	// a subcommand run from cron has a database handle of its own
+	other, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`UPDATE entries SET read = 1`); err != nil {
+		t.Fatal(err)
+	}
+	rec := getEntries("method=all&summary", etag)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("answered %d after another process wrote", rec.Code)
+	}
+	var page []FeedieEntrySummary
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range page {
+		if !e.Read {
+			t.Errorf("%q listed unread", e.Title)
+		}
+	}
+}
+
+// benchmarkDB fills a database with feeds of entries with a few links each.
+func benchmarkDB(b *testing.B) {
+	initTestDB(b)
+	for f := 0; f < 20; f++ {
+		host := fmt.Sprintf("feed%d.example.com", f)
+		entries := []FeedieEntry{}
+		for i := 0; i < 100; i++ {
+			e := newEntry(fmt.Sprint(host, " post ", i), "author", int64(1000+i), "<p>description</p>", "")
+			e.GUID = fmt.Sprint(i)
+			for l := 0; l < 3; l++ {
+				e.Links = append(e.Links, FeedieLink{URL: fmt.Sprintf("http://%s/%d/%d", host, i, l), Type: "text/html"})
+			}
+			entries = append(entries, *e)
+		}
+		DBAddFeedWithEntries(*newFeed(host, "http://"+host+"/feed", entries))
+	}
+}
+
+func BenchmarkGetEntries(b *testing.B) {
+	benchmarkDB(b)
+	const query = "method=all&summary&limit=50&after="
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			invalidateEntryCache()
+			getEntries(query, "")
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			getEntries(query, "")
+		}
+	})
+	b.Run("revalidated", func(b *testing.B) {
+		etag := getEntries(query, "").Header().Get("ETag")
+		for b.Loop() {
+			getEntries(query, etag)
+		}
+	})
+}
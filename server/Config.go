@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The server reads its settings from a JSON file, $HOME/.config/feedie/server.json
+// unless FEEDIE_SERVER_CONFIG points elsewhere. Every setting has a default
+// and the environment variables the server always understood still override
+// the file. A missing file is not an error.
+
+const DEFAULT_USER_AGENT = "Feedie/1.0"
+
+// Duration is a time.Duration written as a string such as "30s" or "2h30m"
+// in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+type TimeoutConfig struct {
+	Fetch Duration `json:"fetch"` // fetching feeds, pages and articles
+	Read  Duration `json:"read"`  // reading a request to the API
+	Write Duration `json:"write"` // writing a response
+	Idle  Duration `json:"idle"`  // keep-alive connections
+}
+
+type RetentionConfig struct {
+	// entries published longer ago are deleted, 0 keeps them forever
+	MaxAgeDays int `json:"max_age_days"`
+	// newest entries kept per feed, 0 for no limit
+	MaxEntriesPerFeed int `json:"max_entries_per_feed"`
+	// starred entries are never deleted unless this is false
+	KeepStarred bool `json:"keep_starred"`
+}
+
+type FetchConfig struct {
+	// largest response body accepted, in bytes after decompression
+	MaxBody      int64 `json:"max_body"`
+	MaxRedirects int   `json:"max_redirects"`
+	// concurrent requests to one host
+	PerHost int `json:"per_host"`
+}
+
+type AuthConfig struct {
+	// required as "Authorization: Bearer <token>" on API requests if set
+	Token string `json:"token"`
+}
+
+type LogConfig struct {
+	// log file, stderr if empty
+	File string `json:"file"`
+	// debug, info, warn or error
+	Level string `json:"level"`
+	// text or json
+	Format string `json:"format"`
+}
+
+type FeedieServerConfig struct {
+	Bind           string          `json:"bind"`
+	Port           int             `json:"port"`
+	DBPath         string          `json:"db_path"`
+	RefreshRate    int64           `json:"refresh_rate"` // seconds
+	RefreshWorkers int             `json:"refresh_workers"`
+	Timeouts       TimeoutConfig   `json:"timeouts"`
+	UserAgent      string          `json:"user_agent"`
+	Proxy          string          `json:"proxy"`
+	Fetch          FetchConfig     `json:"fetch"`
+	Retention      RetentionConfig `json:"retention"`
+	Auth           AuthConfig      `json:"auth"`
+	Log            LogConfig       `json:"log"`
+	Maildir        string          `json:"maildir"`
+	PublicURL      string          `json:"public_url"`
+	AllowCommands  bool            `json:"allow_commands"`
+}
+
+func getDefaultServerConf() FeedieServerConfig {
+	dbPath := ""
+	if home, exists := os.LookupEnv("HOME"); exists {
+		dbPath = home + "/.local/share/feedie/feedie.db"
+	}
+	return FeedieServerConfig{
+		Port:           DEFAULT_PORT,
+		DBPath:         dbPath,
+		RefreshRate:    DEFAULT_REFRESH,
+		RefreshWorkers: 8,
+		Timeouts: TimeoutConfig{
+			Fetch: Duration(60 * time.Second),
+			Read:  Duration(30 * time.Second),
+			Write: Duration(60 * time.Second),
+			Idle:  Duration(120 * time.Second),
+		},
+		UserAgent: DEFAULT_USER_AGENT,
+		Fetch:     FetchConfig{MaxBody: 32 << 20, MaxRedirects: 5, PerHost: 4},
+		Retention: RetentionConfig{KeepStarred: true},
+		Log:       LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+func serverConfigPath() string {
+	if v, exists := os.LookupEnv("FEEDIE_SERVER_CONFIG"); exists {
+		return v
+	}
+	if home, exists := os.LookupEnv("HOME"); exists {
+		return home + "/.config/feedie/server.json"
+	}
+	return ""
+}
+
+// loadServerConfig reads the config file at path, applies environment
+// overrides and validates the result, reporting every problem at once.
+func loadServerConfig(path string) (FeedieServerConfig, error) {
+	conf := getDefaultServerConf()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return conf, err
+		}
+		if err == nil {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&conf); err != nil && err != io.EOF {
+				return conf, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	if err := conf.applyEnv(); err != nil {
+		return conf, err
+	}
+	if errs := conf.validate(); len(errs) > 0 {
+		return conf, fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return conf, nil
+}
+
+func (c *FeedieServerConfig) applyEnv() error {
+	strVars := map[string]*string{
+		"FEEDIE_SERVER_BIND":       &c.Bind,
+		"FEEDIE_SERVER_DB_PATH":    &c.DBPath,
+		"FEEDIE_SERVER_USER_AGENT": &c.UserAgent,
+		"FEEDIE_SERVER_PROXY":      &c.Proxy,
+		"FEEDIE_SERVER_AUTH_TOKEN": &c.Auth.Token,
+		"FEEDIE_SERVER_LOG_FILE":   &c.Log.File,
+		"FEEDIE_SERVER_LOG_LEVEL":  &c.Log.Level,
+		"FEEDIE_SERVER_LOG_FORMAT": &c.Log.Format,
+		"FEEDIE_SERVER_MAILDIR":    &c.Maildir,
+		"FEEDIE_SERVER_PUBLIC_URL": &c.PublicURL,
+	}
+	for name, field := range strVars {
+		if v, exists := os.LookupEnv(name); exists {
+			*field = v
+		}
+	}
+	intVars := map[string]*int{
+		"FEEDIE_SERVER_PORT":            &c.Port,
+		"FEEDIE_SERVER_REFRESH_WORKERS": &c.RefreshWorkers,
+	}
+	for name, field := range intVars {
+		if v, exists := os.LookupEnv(name); exists {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*field = n
+		}
+	}
+	if v, exists := os.LookupEnv("FEEDIE_SERVER_REFRESH_RATE"); exists {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("FEEDIE_SERVER_REFRESH_RATE: %w", err)
+		}
+		c.RefreshRate = n
+	}
+	if v, exists := os.LookupEnv("FEEDIE_SERVER_ALLOW_COMMANDS"); exists {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("FEEDIE_SERVER_ALLOW_COMMANDS: %w", err)
+		}
+		c.AllowCommands = b
+	}
+	return nil
+}
+
+func (c FeedieServerConfig) validate() []string {
+	errs := []string{}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port: %d is not between 1 and 65535", c.Port))
+	}
+	if c.DBPath == "" {
+		errs = append(errs, "db_path: must be set when $HOME is not")
+	}
+	if c.RefreshRate < 60 {
+		errs = append(errs, fmt.Sprintf("refresh_rate: %d is less than 60 seconds", c.RefreshRate))
+	}
+	if c.RefreshWorkers < 1 {
+		errs = append(errs, fmt.Sprintf("refresh_workers: %d is less than 1", c.RefreshWorkers))
+	}
+	timeouts := []struct {
+		name string
+		d    Duration
+	}{
+		{"fetch", c.Timeouts.Fetch}, {"read", c.Timeouts.Read},
+		{"write", c.Timeouts.Write}, {"idle", c.Timeouts.Idle},
+	}
+	for _, t := range timeouts {
+		if t.d <= 0 {
+			errs = append(errs, fmt.Sprintf("timeouts.%s: must be positive", t.name))
+		}
+	}
+	if c.UserAgent == "" {
+		errs = append(errs, "user_agent: must not be empty")
+	}
+	if c.Proxy != "" {
+		if u, err := url.Parse(c.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("proxy: %q is not a URL", c.Proxy))
+		}
+	}
+	if c.Fetch.MaxBody < 1 {
+		errs = append(errs, "fetch.max_body: must be positive")
+	}
+	if c.Fetch.MaxRedirects < 0 {
+		errs = append(errs, "fetch.max_redirects: must not be negative")
+	}
+	if c.Fetch.PerHost < 1 {
+		errs = append(errs, fmt.Sprintf("fetch.per_host: %d is less than 1", c.Fetch.PerHost))
+	}
+	if c.Retention.MaxAgeDays < 0 {
+		errs = append(errs, "retention.max_age_days: must not be negative")
+	}
+	if c.Retention.MaxEntriesPerFeed < 0 {
+		errs = append(errs, "retention.max_entries_per_feed: must not be negative")
+	}
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Sprintf("log.level: %q is not debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Sprintf("log.format: %q is not text or json", c.Log.Format))
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("public_url: %q is not an http(s) URL", c.PublicURL))
+		}
+	}
+	return errs
+}
+
+// redacted returns a copy safe to print.
+func (c FeedieServerConfig) redacted() FeedieServerConfig {
+	if c.Auth.Token != "" {
+		c.Auth.Token = "<redacted>"
+	}
+	return c
+}
+
+func printServerConfig(w io.Writer, conf FeedieServerConfig) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(conf.redacted())
+}
+
+// userAgentTransport sets the configured User-Agent on outgoing requests that
+// don't set their own.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("User-Agent") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// configureHTTP sets up the fetcher, and makes every other outgoing request,
+// to webhooks and WebSub hubs, use the configured proxy and User-Agent too.
+// Without a proxy setting the usual *_PROXY variables apply.
+func configureHTTP(conf FeedieServerConfig) {
+	httpFetcher = NewFetcher(conf)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.Proxy != "" {
+		proxy, _ := url.Parse(conf.Proxy)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	http.DefaultTransport = userAgentTransport{base: transport, userAgent: conf.UserAgent}
+}
+
+// publicPaths are served without the auth token: published tag feeds and
+// WebSub hub callbacks.
+var publicPaths = []string{"/feeds/tag/", websubCallbackPath}
+
+// requireToken rejects API requests without the configured token.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, p := range publicPaths {
+			if strings.HasPrefix(r.URL.Path, p) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" {
+			got = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="feedie"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
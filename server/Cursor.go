@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Entry lists can be paged with cursors instead of offsets, so entries added
+// while a client pages through a list don't shift the pages after them. A
+// cursor is the key of the entry it was taken from in the entrySort of the
+// list, the values it orders by and the id, encoded so clients treat it as
+// opaque.
+
+type entryCursor struct {
+	Sort string
+	Key  []any
+	ID   int64
+}
+
+// entryPage selects the entries of an entry query to return and their
+// order: Limit entries (or all of them when negative) from Offset, or from
+// Cursor when one is set, of the entries Filter lets through sorted by the
+// entrySort named Sort, or the default one. A page with a cursor holds the
+// entries after it in the query's order, or the ones before it when
+// Backward is set; a Backward page without one is the last page of the
+// list.
+type entryPage struct {
+	Limit    int
+	Offset   int
+	Cursor   *entryCursor
+	Backward bool
+	Sort     string
+	Filter   entryFilter
+}
+
+// allEntries is the page holding every entry of a query.
+var allEntries = entryPage{Limit: -1}
+
+func (p entryPage) sortName() string {
+	if _, ok := entrySorts[p.Sort]; ok {
+		return p.Sort
+	}
+	return defaultEntrySort
+}
+
+func cursorOf(e FeedieEntry, sort string) entryCursor {
+	return entryCursor{Sort: sort, Key: entrySorts[sort].key(e), ID: e.ID}
+}
+
+func (c entryCursor) String() string {
+	raw, _ := json.Marshal(append(append([]any{c.Sort}, c.Key...), c.ID))
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// parseEntryCursor decodes a cursor taken from a list sorted by sort.
+func parseEntryCursor(s, sort string) (*entryCursor, error) {
+	invalid := errors.New("invalid cursor")
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var values []any
+	if err := dec.Decode(&values); err != nil || len(values) != len(entrySorts[sort].columns)+2 {
+		return nil, invalid
+	}
+	if name, ok := values[0].(string); !ok || name != sort {
+		return nil, errors.New("cursor of another sort")
+	}
+	c := entryCursor{Sort: sort}
+	for _, v := range values[1:] {
+		switch v := v.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, invalid
+			}
+			c.Key = append(c.Key, n)
+		case string:
+			c.Key = append(c.Key, v)
+		default:
+			return nil, invalid
+		}
+	}
+	// the id follows the key
+	id, ok := c.Key[len(c.Key)-1].(int64)
+	if !ok || id <= 0 {
+		return nil, invalid
+	}
+	c.Key, c.ID = c.Key[:len(c.Key)-1], id
+	return &c, nil
+}
+
+// entryCursorPage is the response to a /get_entries query paged with a
+// cursor. Next continues the list after its entries and Prev before them;
+// either is empty once the list has no more entries that way.
+type entryCursorPage struct {
+	Entries any
+	Next    string
+	Prev    string
+}
+
+// cutEntryPage returns the entries of page, read with a limit of one more
+// entry to tell whether the list goes on, and the cursors around them.
+func cutEntryPage(entries []FeedieEntry, page entryPage) ([]FeedieEntry, entryCursorPage) {
+	var ret entryCursorPage
+	more := page.Limit >= 0 && len(entries) == page.Limit
+	if more {
+		if page.Backward {
+			entries = entries[1:]
+		} else {
+			entries = entries[:len(entries)-1]
+		}
+	}
+	if len(entries) == 0 {
+		// past either end of the list, the way back is the cursor itself
+		if page.Cursor != nil {
+			if page.Backward {
+				ret.Next = page.Cursor.String()
+			} else {
+				ret.Prev = page.Cursor.String()
+			}
+		}
+		return entries, ret
+	}
+	first := cursorOf(entries[0], page.sortName()).String()
+	last := cursorOf(entries[len(entries)-1], page.sortName()).String()
+	// the list goes on the way the page was read if the extra entry was
+	// found, and the other way if it was read from a cursor
+	if page.Backward {
+		if more {
+			ret.Prev = first
+		}
+		if page.Cursor != nil {
+			ret.Next = last
+		}
+	} else {
+		if more {
+			ret.Next = last
+		}
+		if page.Cursor != nil {
+			ret.Prev = first
+		}
+	}
+	return entries, ret
+}
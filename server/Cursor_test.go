@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// getEntryPage requests one /get_entries page of summaries.
+func getEntryPage(t *testing.T, query url.Values) (ids []int64, next, prev string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	getEntriesHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entries?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s answered %d: %s", query.Encode(), rec.Code, rec.Body)
+	}
+	var page struct {
+		Entries    []FeedieEntrySummary
+		Next, Prev string
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range page.Entries {
+		ids = append(ids, e.ID)
+	}
+	return ids, page.Next, page.Prev
+}
+
+func TestCursorPagination(t *testing.T) {
+	initTestDB(t)
+	// entries of both feeds are published at the same times
+	DBAddFeedWithEntries(numberedFeed("a.example.com", 1000, 3))
+	DBAddFeedWithEntries(numberedFeed("b.example.com", 1000, 3))
+
+	for _, order := range []timeOrder{DESC, ASC} {
+		want := []int64{}
+		for _, e := range DBGetAllTimeOrdered(order, allEntries) {
+			want = append(want, e.ID)
+		}
+		query := url.Values{"method": {"all"}, "summary": {""}, "limit": {"4"}, "after": {""}}
+		if order == ASC {
+			query.Set("rev", "")
+		}
+
+		first, next, prev := getEntryPage(t, query)
+		if prev != "" || next == "" {
+			t.Fatalf("first page cursors %q %q", prev, next)
+		}
+		query.Set("after", next)
+		rest, next, prev := getEntryPage(t, query)
+		if next != "" || prev == "" {
+			t.Fatalf("last page cursors %q %q", prev, next)
+		}
+		if got := append(first, rest...); !slices.Equal(got, want) {
+			t.Fatalf("paging forward got %v, want %v", got, want)
+		}
+
+		// back from the last page
+		query.Del("after")
+		query.Set("before", prev)
+		back, _, prev := getEntryPage(t, query)
+		if !slices.Equal(back, first) || prev != "" {
+			t.Errorf("paging back got %v and %q, want %v", back, prev, first)
+		}
+		query.Set("before", "")
+		if last, _, _ := getEntryPage(t, query); !slices.Equal(last, want[2:]) {
+			t.Errorf("last page %v, want %v", last, want[2:])
+		}
+	}
+
+	// entries added while paging don't shift the pages
+	_, next, _ := getEntryPage(t, url.Values{"method": {"all"}, "limit": {"2"}, "after": {""}})
+	DBAddFeedWithEntries(numberedFeed("c.example.com", 2000, 3))
+	ids, _, _ := getEntryPage(t, url.Values{"method": {"all"}, "limit": {"2"}, "after": {next}})
+	if want := DBGetAllTimeOrdered(DESC, entryPage{Limit: 2, Offset: 5}); ids[0] != want[0].ID {
+		t.Errorf("page after the cursor starts at %d, want %d", ids[0], want[0].ID)
+	}
+
+	rec := httptest.NewRecorder()
+	getEntriesHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entries?method=all&after=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursor answered %d", rec.Code)
+	}
+}
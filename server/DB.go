@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"hash/fnv"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -36,64 +39,227 @@ func DBInit(path string) {
 		log.Fatal(err)
 	}
 
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS feeds (
-		id TEXT PRIMARY KEY,
-		title TEXT,
-		url TEXT
-	);`)
-	if err != nil{
-		log.Fatal(err)
+	// tables from before surrogate keys are rebuilt first
+	if hasColumn("feeds", "id") && !hasColumn("feeds", "hash") {
+		migrateSurrogateKeys()
+	}
+	if hasColumn("purged_entries", "id") {
+		migratePurgedEntries()
+	}
+	createTables(db)
+	ensureColumn("feeds", "favicon", "TEXT NOT NULL DEFAULT ''")
+	ensureColumn("entries", "first_seen", "INTEGER NOT NULL DEFAULT 0")
+	db.SetMaxOpenConns(0)
+	watchDatabase(db)
+}
+
+// DBOpenReadOnly opens the database at path for reading only, without
+// creating or migrating it.
+func DBOpenReadOnly(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	var err error
+	db, err = sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=foreign_keys(1)&_pragma=query_only(1)", path))
+	if err != nil {
+		return err
 	}
+	if err := db.Ping(); err != nil {
+		return err
+	}
+	watchDatabase(db)
+	return nil
+}
 
-	_, err = db.Exec(`
+// createTables creates the tables and indexes missing from the database.
+// Feeds, entries, links and tags have integer ids; the FNV hashes that used
+// to be their ids are kept in the hash column, the callback path of WebSub
+// subscriptions and the ids entries are republished with are derived from
+// them.
+func createTables(conn interface{ Exec(string, ...any) (sql.Result, error) }) {
+	statements := []string{`
+	CREATE TABLE IF NOT EXISTS feeds (
+		id INTEGER PRIMARY KEY,
+		hash TEXT NOT NULL UNIQUE,
+		title TEXT,
+		url TEXT NOT NULL UNIQUE,
+		full_text INTEGER NOT NULL DEFAULT 0,
+		dead INTEGER NOT NULL DEFAULT 0,
+		favicon TEXT NOT NULL DEFAULT ''
+	);`, `
 	CREATE TABLE IF NOT EXISTS entries (
-		id TEXT PRIMARY KEY,
-		feed_id TEXT NOT NULL,
+		id INTEGER PRIMARY KEY,
+		hash TEXT NOT NULL,
+		feed_id INTEGER NOT NULL,
 		title TEXT,
 		author TEXT,
 		published INTEGER,
 		description TEXT,
 		thumbnail TEXT,
+		guid TEXT NOT NULL,
+		read INTEGER NOT NULL DEFAULT 0,
+		starred INTEGER NOT NULL DEFAULT 0,
+		hidden INTEGER NOT NULL DEFAULT 0,
+		first_seen INTEGER NOT NULL DEFAULT 0,
+		UNIQUE (feed_id, guid),
 		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
-	);`)
-	if err != nil{
-		log.Fatal(err)
-	}
-
-	_, err = db.Exec(`
+	);`, `
 	CREATE TABLE IF NOT EXISTS links (
-		id TEXT PRIMARY KEY,
+		id INTEGER PRIMARY KEY,
 		url TEXT NOT NULL,
-		entry_id TEXT NOT NULL,
+		entry_id INTEGER NOT NULL,
 		link_type TEXT,
+		UNIQUE (entry_id, url),
 		FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
-	);`)
-	if err != nil{
-		log.Fatal(err)
-	}
-
-	_, err = db.Exec(`
+	);`, `
 	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY,
+		hash TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL UNIQUE,
+		public INTEGER NOT NULL DEFAULT 0
+	);`, `
+	CREATE TABLE IF NOT EXISTS tag_members (
+		tag_id INTEGER NOT NULL,
+		feed_id INTEGER NOT NULL,
+		PRIMARY KEY (tag_id, feed_id),
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS entry_tags (
+		tag_id INTEGER NOT NULL,
+		entry_id INTEGER NOT NULL,
+		PRIMARY KEY (tag_id, entry_id),
+		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+		FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS articles (
+		entry_id INTEGER PRIMARY KEY,
+		content TEXT NOT NULL,
+		fetched INTEGER NOT NULL,
+		FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS podcasts (
+		entry_id INTEGER PRIMARY KEY,
+		duration INTEGER NOT NULL DEFAULT 0,
+		size INTEGER NOT NULL DEFAULT 0,
+		episode INTEGER NOT NULL DEFAULT 0,
+		season INTEGER NOT NULL DEFAULT 0,
+		chapters_url TEXT NOT NULL DEFAULT '',
+		chapters TEXT NOT NULL DEFAULT 'null',
+		position INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS videos (
+		entry_id INTEGER PRIMARY KEY,
+		views INTEGER NOT NULL DEFAULT 0,
+		rating_average REAL NOT NULL DEFAULT 0,
+		rating_count INTEGER NOT NULL DEFAULT 0,
+		duration INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS rules (
 		id TEXT PRIMARY KEY,
-		name TEXT
-	);`)
+		field TEXT NOT NULL,
+		match_type TEXT NOT NULL,
+		pattern TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		scope_value TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		action_value TEXT NOT NULL DEFAULT ''
+	);`, `
+	CREATE TABLE IF NOT EXISTS feed_sources (
+		feed_id INTEGER PRIMARY KEY,
+		adapter TEXT NOT NULL,
+		config TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS purged_entries (
+		feed_id INTEGER NOT NULL,
+		guid TEXT NOT NULL,
+		PRIMARY KEY (feed_id, guid),
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS websub (
+		feed_id INTEGER PRIMARY KEY,
+		hub TEXT NOT NULL,
+		topic TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		state TEXT NOT NULL,
+		lease_expires INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		scope_value TEXT NOT NULL DEFAULT '',
+		template TEXT NOT NULL DEFAULT ''
+	);`, `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id TEXT NOT NULL,
+		entry_id INTEGER NOT NULL DEFAULT 0,
+		attempt INTEGER NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		time INTEGER NOT NULL,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+	);`, `
+	CREATE TABLE IF NOT EXISTS feed_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER NOT NULL,
+		time INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		detail TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	);`,
+	`CREATE INDEX IF NOT EXISTS entries_hash ON entries (hash);`,
+	`CREATE INDEX IF NOT EXISTS links_url ON links (url);`,
+	`CREATE INDEX IF NOT EXISTS tag_members_feed ON tag_members (feed_id);`,
+	`CREATE INDEX IF NOT EXISTS feed_history_feed ON feed_history (feed_id);`,
+	}
+	for _, statement := range statements {
+		if _, err := conn.Exec(statement); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// hasColumn reports whether table exists and has column. Callers must
+// hold dbMu.
+func hasColumn(table, column string) bool {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil{
 		log.Fatal(err)
 	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			log.Fatal(err)
+		}
+		if name == column {
+			found = true
+		}
+	}
+	return found
+}
 
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS tag_members (
-		tag_id TEXT NOT NULL,
-		feed_id TEXT NOT NULL,
-		PRIMARY KEY (tag_id, feed_id),
-		FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
-		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
-	);`)
+// ensureColumn adds a column to a table created by an older version of the
+// schema. Callers must hold dbMu.
+func ensureColumn(table, column, decl string) {
+	if hasColumn(table, column) {
+		return
+	}
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
 	if err != nil{
 		log.Fatal(err)
 	}
-	db.SetMaxOpenConns(0)
 }
 
 func GetHashString(root string) string{
@@ -112,61 +278,22 @@ func DBExecuteSQL(statement string, args []any){
 }
 
 func DBAddFeed(feed FeedieFeed){
-	hash := GetHashString(feed.Url)
 	dbMu.Lock()
 	defer dbMu.Unlock()
-	statement :=`INSERT INTO feeds (id, title, url)
+	statement :=`INSERT INTO feeds (hash, title, url)
 VALUES (?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-    title = excluded.title,
-    url = excluded.url;
-`
-	_, err := db.Exec(statement, hash, feed.Title, feed.Url)
-	if err != nil{
-		log.Fatal(err)
-	}
-}
-
-
-func DBAddEntry(feed FeedieFeed, entry FeedieEntry){
-	feed_id := GetHashString(feed.Url)
-	entry_id := GetHashString(entry.getHashString())
-
-	statement :=`INSERT INTO entries 
-	(id, feed_id, title, author, published, description, thumbnail)
-VALUES (?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-    feed_id = excluded.feed_id,
-    title = excluded.title,
-    author = excluded.author,
-    published = excluded.published,
-    description = excluded.description,
-    thumbnail = excluded.thumbnail;
+ON CONFLICT(url) DO UPDATE SET
+    title = excluded.title;
 `
-	dbMu.Lock()
-	defer dbMu.Unlock()
-	_, err := db.Exec(statement,entry_id,feed_id,entry.Title,entry.Author,entry.Published, entry.Description, entry.Thumbnail)
+	_, err := db.Exec(statement, GetHashString(feed.Url), feed.Title, feed.Url)
 	if err != nil{
 		log.Fatal(err)
 	}
-
-	statement = `INSERT INTO links
-	(id, url, entry_id, link_type)
-	VALUES (?,?,?,?)
-	ON CONFLICT(id) DO UPDATE SET
-	entry_id = excluded.entry_id,
-	link_type = excluded.link_type;`
-
-	for _, link := range entry.Links{
-		_, err = db.Exec(statement,GetHashString(link.URL+entry_id),link.URL,entry_id,link.Type)
-		if err != nil{
-			log.Fatal(err)
-		}
-	}
+	invalidateEntryCache()
 }
 
 func DBAddFeedWithEntries(feed FeedieFeed){
-	feed_id := GetHashString(feed.Url)
+	defer observeQuery("add_feed_with_entries")()
 
 	dbMu.Lock()
 	defer dbMu.Unlock()
@@ -174,62 +301,121 @@ func DBAddFeedWithEntries(feed FeedieFeed){
 	tx, err := db.Begin()
 	if err != nil { log.Fatal(err) }
 
-	_, err = tx.Exec(`INSERT INTO feeds (id, title, url)
-VALUES (?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+	// fetched fine, so a feed that was gone is back
+	var dead bool
+	// webhooks only fire for entries of feeds that already had some
+	var hasEntries bool
+	err = tx.QueryRow(`SELECT dead, EXISTS (SELECT 1 FROM entries WHERE feed_id = feeds.id)
+FROM feeds WHERE url = ?`, feed.Url).Scan(&dead, &hasEntries)
+	if err != nil && err != sql.ErrNoRows { tx.Rollback(); log.Fatal(err) }
+
+	err = tx.QueryRow(`INSERT INTO feeds (hash, title, url, favicon)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(url) DO UPDATE SET
     title = excluded.title,
-    url = excluded.url;`, feed_id, feed.Title, feed.Url)
+    favicon = excluded.favicon,
+    dead = 0
+RETURNING id;`, GetHashString(feed.Url), feed.Title, feed.Url, feed.FaviconURL).Scan(&feed.ID)
 	if err != nil { tx.Rollback(); log.Fatal(err) }
+	if dead {
+		addFeedEvent(tx, feed.ID, feedEventRevived, "")
+	}
+
+	rules := loadRulesForFeed(tx, feed)
+	hooks, tags := loadWebhooks(tx, feed)
+	jobs := []webhookJob{}
 
 	for _, entry := range feed.Entries {
-		entry_id := GetHashString(entry.getHashString())
-		_, err = tx.Exec(`INSERT INTO entries
-(id, feed_id, title, author, published, description, thumbnail)
-VALUES (?, ?, ?, ?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
-    feed_id = excluded.feed_id,
+		key := entry.getHashString()
+		hash := entryHash(feed.ID, key)
+		var exists, purged int
+		err = tx.QueryRow(`SELECT
+(SELECT COUNT(*) FROM entries WHERE feed_id = ? AND guid = ?),
+(SELECT COUNT(*) FROM purged_entries WHERE feed_id = ? AND guid = ?)`,
+			feed.ID, key, feed.ID, key).Scan(&exists, &purged)
+		if err != nil { tx.Rollback(); log.Fatal(err) }
+		// deleted by retention, don't bring it back
+		if purged > 0 {
+			continue
+		}
+		// entries from before GUIDs were stored were migrated with their
+		// title, author and date as GUID, and kept the hash of their key
+		if exists == 0 {
+			res, err := tx.Exec(`UPDATE entries SET guid = ? WHERE feed_id = ? AND hash = ?`,
+				key, feed.ID, GetHashString(key))
+			if err != nil { tx.Rollback(); log.Fatal(err) }
+			if n, _ := res.RowsAffected(); n > 0 {
+				exists = 1
+			}
+		}
+
+		// entries without a date are given the time they were fetched, so
+		// they keep the one they were first stored with
+		var entryID int64
+		err = tx.QueryRow(`INSERT INTO entries
+(hash, feed_id, title, author, published, description, thumbnail, guid, first_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(feed_id, guid) DO UPDATE SET
     title = excluded.title,
     author = excluded.author,
-    published = excluded.published,
+    published = CASE WHEN ? THEN entries.published ELSE excluded.published END,
     description = excluded.description,
-    thumbnail = excluded.thumbnail;`,
-			entry_id, feed_id, entry.Title, entry.Author, entry.Published, entry.Description, entry.Thumbnail)
+    thumbnail = excluded.thumbnail
+RETURNING id;`,
+			hash, feed.ID, entry.Title, entry.Author, entry.Published, entry.Description, entry.Thumbnail, key, time.Now().Unix(),
+			entry.undated).Scan(&entryID)
 		if err != nil { tx.Rollback(); log.Fatal(err) }
 
 		for _, link := range entry.Links {
-			_, err = tx.Exec(`INSERT INTO links (id, url, entry_id, link_type)
-VALUES (?,?,?,?)
-ON CONFLICT(id) DO UPDATE SET
-    entry_id = excluded.entry_id,
+			_, err = tx.Exec(`INSERT INTO links (url, entry_id, link_type)
+VALUES (?,?,?)
+ON CONFLICT(entry_id, url) DO UPDATE SET
     link_type = excluded.link_type;`,
-				GetHashString(link.URL+entry_id), link.URL, entry_id, link.Type)
+				link.URL, entryID, link.Type)
 			if err != nil { tx.Rollback(); log.Fatal(err) }
 		}
+
+		if entry.Podcast != nil {
+			upsertPodcast(tx, entryID, entry.Podcast)
+		}
+		if entry.Video != nil {
+			upsertVideo(tx, entryID, entry.Video)
+		}
+
+		// rules only act on entries seen for the first time, so a user
+		// un-hiding or un-reading an entry isn't overridden on refresh
+		if exists == 0 {
+			matched := applyRules(tx, rules, entryID, entry)
+			if hasEntries {
+				jobs = append(jobs, webhookJobs(hooks, tags, matched, feed, entryID, entry)...)
+			}
+		}
 	}
 
+	prunePurgedEntries(tx, feed)
+
 	if err = tx.Commit(); err != nil { log.Fatal(err) }
+	invalidateEntryCache()
+	deliverWebhooks(jobs)
 }
 
 func DBAddTag(tagName string) {
-	tagID := GetHashString(tagName)
-
 	statement := `INSERT INTO tags
-	(id, name)
+	(hash, name)
 	VALUES (?,?)
-	ON CONFLICT(id) DO UPDATE SET
-	name = excluded.name;`
+	ON CONFLICT(name) DO NOTHING;`
 
 	dbMu.Lock()
 	defer dbMu.Unlock()
-	_, err := db.Exec(statement, tagID, tagName)
+	_, err := db.Exec(statement, GetHashString(tagName), tagName)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func DBAddFeedToTag(feed FeedieFeed, tag string) {
-	var feedID string
-	var tagID string
+	var feedID int64
+	var tagID int64
 
 	dbMu.RLock()
 	feedErr := db.QueryRow("SELECT id FROM feeds WHERE title = ? AND url = ?", feed.Title, feed.Url).Scan(&feedID)
@@ -238,14 +424,14 @@ func DBAddFeedToTag(feed FeedieFeed, tag string) {
 
 	if feedErr != nil {
 		if feedErr == sql.ErrNoRows {
-			log.Println("No matching feed found")
+			slog.Warn("no matching feed found", "feed", feed.Url)
 			return
 		}
 		log.Fatal(feedErr)
 	}
 	if tagErr != nil {
 		if tagErr == sql.ErrNoRows {
-			log.Println("No matching tag found, creating it")
+			slog.Info("no matching tag found, creating it", "tag", tag)
 			DBAddTag(tag)
 			DBAddFeedToTag(feed, tag)
 			return
@@ -261,19 +447,25 @@ func DBAddFeedToTag(feed FeedieFeed, tag string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 
 // scanEntries aggregates a LEFT JOIN result (entries + links) into []FeedieEntry.
-// Each entry may appear on multiple rows (one per link); NULL link columns mean no links.
+// Each entry may appear on multiple rows (one per link), which must be consecutive;
+// NULL link columns mean no links.
+// Callers must hold dbMu, metadata from other tables is loaded once rows is drained.
 func scanEntries(rows *sql.Rows) []FeedieEntry {
 	ret := []FeedieEntry{}
 	var cur *FeedieEntry
-	var curID string
+	var curID int64
 	for rows.Next() {
-		var id, title, author, description, thumbnail string
-		var published int64
+		var id, published, firstSeen, feedID int64
+		var hash, title, author, description, thumbnail, feedTitle, favicon string
+		var guid sql.NullString
+		var read, starred bool
 		var linkURL, linkType sql.NullString
-		err := rows.Scan(&id, &title, &author, &description, &thumbnail, &published, &linkURL, &linkType)
+		err := rows.Scan(&id, &hash, &title, &author, &description, &thumbnail, &published, &guid, &read, &starred,
+			&firstSeen, &feedID, &feedTitle, &favicon, &linkURL, &linkType)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -282,6 +474,15 @@ func scanEntries(rows *sql.Rows) []FeedieEntry {
 				ret = append(ret, *cur)
 			}
 			cur = newEntry(title, author, published, description, thumbnail)
+			cur.ID = id
+			cur.hash = hash
+			cur.GUID = guid.String
+			cur.Read = read
+			cur.Starred = starred
+			cur.FirstSeen = firstSeen
+			cur.FeedID = feedID
+			cur.FeedTitle = feedTitle
+			cur.FaviconURL = favicon
 			curID = id
 		}
 		if linkURL.Valid {
@@ -291,101 +492,171 @@ func scanEntries(rows *sql.Rows) []FeedieEntry {
 	if cur != nil {
 		ret = append(ret, *cur)
 	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	attachEntryMeta(ret)
 	return ret
 }
 
-func DBGetAllTimeOrdered(isAsc timeOrder, limit, offset int) []FeedieEntry{
-	query := `
-SELECT e.id, e.title, e.author, e.description, e.thumbnail, e.published,
-       l.url, l.link_type
-FROM entries e
-LEFT JOIN links l ON l.entry_id = e.id
-ORDER BY e.published DESC, e.id
-LIMIT ? OFFSET ?`
-	if isAsc{
-		query = strings.Replace(query, "DESC", "ASC", 1)
+// attachEntryMeta loads the metadata kept outside the entries table for
+// entries returned by scanEntries. Callers must hold dbMu.
+func attachEntryMeta(entries []FeedieEntry) {
+	byID := make(map[int64]*FeedieEntry, len(entries))
+	ids := make([]any, 0, len(entries))
+	for i := range entries {
+		byID[entries[i].ID] = &entries[i]
+		ids = append(ids, entries[i].ID)
+	}
+	// stay well under sqlite's bound parameter limit
+	const chunk = 500
+	for start := 0; start < len(ids); start += chunk {
+		part := ids[start:min(start+chunk, len(ids))]
+		attachPodcasts(byID, part)
+		attachVideos(byID, part)
 	}
+}
+
+// entryColumns are the columns of entries, their feeds and links read by
+// scanEntries.
+const entryColumns = `e.id, e.hash, e.title, e.author, e.description, e.thumbnail, e.published,
+       e.guid, e.read, e.starred, e.first_seen, f.id, f.title, f.favicon, l.url, l.link_type`
+
+// queryEntryPage returns page of the visible entries matching where, a list
+// of conditions each starting with AND, in the order of the page's sort.
+func queryEntryPage(where string, args []any, isAsc timeOrder, page entryPage) []FeedieEntry {
+	// pages before a cursor are read from it backwards, then put in order
+	dir, cmp := "DESC", "<"
+	if bool(isAsc) != page.Backward {
+		dir, cmp = "ASC", ">"
+	}
+	columns := append(slices.Clone(entrySorts[page.sortName()].columns), "e.id")
+	order := strings.Join(columns, " "+dir+", ") + " " + dir
+	filter, filterArgs := page.Filter.where()
+	where += filter
+	args = append(args, filterArgs...)
+	offset := page.Offset
+	if page.Cursor != nil {
+		where += fmt.Sprintf(`
+AND (%s) %s (%s)`, strings.Join(columns, ", "), cmp, strings.Repeat("?, ", len(columns)-1)+"?")
+		args = append(append(args, page.Cursor.Key...), page.Cursor.ID)
+		offset = 0
+	}
+	// the page is taken from the entries alone, so entries with several
+	// links count once against the limit, and their links joined after
+	query := fmt.Sprintf(`
+SELECT %s
+FROM (
+	SELECT e.* FROM entries AS e
+	JOIN feeds AS f ON e.feed_id = f.id
+	WHERE e.hidden = 0%s
+	ORDER BY %s
+	LIMIT ? OFFSET ?) AS e
+JOIN feeds AS f ON e.feed_id = f.id
+LEFT JOIN links l ON l.entry_id = e.id
+ORDER BY %s, l.id`, entryColumns, where, order, order)
+	args = append(args, page.Limit, offset)
 	dbMu.RLock()
 	defer dbMu.RUnlock()
-	rows, err := db.Query(query, limit, offset)
+	rows, err := db.Query(query, args...)
 	if err != nil{
 		log.Fatal(err)
 	}
 	defer rows.Close()
-	return scanEntries(rows)
+	entries := scanEntries(rows)
+	if page.Backward {
+		slices.Reverse(entries)
+	}
+	return entries
+}
+
+func DBGetAllTimeOrdered(isAsc timeOrder, page entryPage) []FeedieEntry{
+	defer observeQuery("entries_all")()
+	return queryEntryPage("", nil, isAsc, page)
+}
+
+func DBGetByTagTimeOrdered(tag string, isAsc timeOrder, page entryPage) []FeedieEntry{
+	defer observeQuery("entries_by_tag")()
+	where := `
+AND (e.feed_id IN (
+	SELECT tm.feed_id FROM tag_members AS tm
+	JOIN tags AS t ON tm.tag_id = t.id
+	WHERE t.name = ?)
+OR e.id IN (
+	SELECT et.entry_id FROM entry_tags AS et
+	JOIN tags AS t ON et.tag_id = t.id
+	WHERE t.name = ?))`
+	return queryEntryPage(where, []any{tag, tag}, isAsc, page)
 }
 
-func DBGetByTagTimeOrdered(tag string, isAsc timeOrder, limit, offset int) []FeedieEntry{
+// DBSearchTimeOrdered returns entries whose title, author or description
+// contain every word of search.
+func DBSearchTimeOrdered(search string, isAsc timeOrder, page entryPage) []FeedieEntry{
+	defer observeQuery("entries_search")()
+	where := ""
+	args := []any{}
+	for _, word := range strings.Fields(search){
+		where += `
+AND (e.title LIKE ? OR e.author LIKE ? OR e.description LIKE ?)`
+		pattern := "%" + word + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	return queryEntryPage(where, args, isAsc, page)
+}
+
+// DBGetEntry returns the entry with id, including hidden ones.
+func DBGetEntry(id int64) (FeedieEntry, bool) {
+	defer observeQuery("entry")()
 	query := `
-SELECT e.id, e.title, e.author, e.description, e.thumbnail, e.published,
-       l.url, l.link_type
+SELECT ` + entryColumns + `
 FROM entries AS e
 JOIN feeds AS f ON e.feed_id = f.id
-JOIN tag_members AS tm ON f.id = tm.feed_id
-JOIN tags AS t ON tm.tag_id = t.id
 LEFT JOIN links l ON l.entry_id = e.id
-WHERE t.name = ?
-ORDER BY e.published DESC, e.id 
-LIMIT ? OFFSET ?`
-	if isAsc{
-		query = strings.Replace(query, "DESC", "ASC", 1)
-	}
+WHERE e.id = ?`
 	dbMu.RLock()
 	defer dbMu.RUnlock()
-	rows, err := db.Query(query, tag, limit, offset)
+	rows, err := db.Query(query, id)
 	if err != nil{
 		log.Fatal(err)
 	}
 	defer rows.Close()
-	return scanEntries(rows)
+	entries := scanEntries(rows)
+	if len(entries) == 0{
+		return FeedieEntry{}, false
+	}
+	return entries[0], true
 }
 
 func DBGetFeedByName(name string) FeedieFeed {
-	query := `SELECT title, url FROM feeds WHERE title = ?`
+	query := `SELECT id, title, url FROM feeds WHERE title = ?`
+	var id int64
 	var title, url string
 	dbMu.RLock()
 	feedData := db.QueryRow(query, name)
 	dbMu.RUnlock()
-	err := feedData.Scan(&title, &url)
+	err := feedData.Scan(&id, &title, &url)
 	if err != nil{
 		if err == sql.ErrNoRows{
 			return FeedieFeed{}
 		}
 		log.Fatal(err)
 	}
-	feed := FeedieFeed{Title: title, Url: url}
+	feed := FeedieFeed{ID: id, Title: title, Url: url}
 
 	return feed
 
 }
 
-func DBGetByFeedTimeOrdered(feed FeedieFeed, isAsc timeOrder, limit, offset int) []FeedieEntry {
-	feedID := GetHashString(feed.Url)
-	query := `
-SELECT e.id, e.title, e.author, e.description, e.thumbnail, e.published,
-       l.url, l.link_type
-FROM entries AS e
-JOIN feeds AS f ON e.feed_id = f.id
-LEFT JOIN links l ON l.entry_id = e.id
-WHERE f.id = ?
-ORDER BY e.published DESC, e.id
-LIMIT ? OFFSET ?`
-	if isAsc{
-		query = strings.Replace(query, "DESC", "ASC", 1)
-	}
-	dbMu.RLock()
-	defer dbMu.RUnlock()
-	rows, err := db.Query(query, feedID, limit, offset)
-	if err != nil{
-		log.Fatal(err)
-	}
-	defer rows.Close()
-	return scanEntries(rows)
+func DBGetByFeedTimeOrdered(feed FeedieFeed, isAsc timeOrder, page entryPage) []FeedieEntry {
+	defer observeQuery("entries_by_feed")()
+	return queryEntryPage(`
+AND f.url = ?`, []any{feed.Url}, isAsc, page)
 }
 
 func DBGetFeeds(withEntries bool ) []FeedieFeed{
+	defer observeQuery("feeds")()
 	ret := []FeedieFeed{}
-	query := `SELECT title, url FROM feeds`
+	query := `SELECT id, title, url, full_text, dead, favicon FROM feeds`
 	dbMu.RLock()
 	defer dbMu.RUnlock()
 	feeds, err := db.Query(query)
@@ -394,15 +665,18 @@ func DBGetFeeds(withEntries bool ) []FeedieFeed{
 	}
 	defer feeds.Close()
 	for feeds.Next() {
+		var id int64
 		var title, url string
-		err = feeds.Scan(&title, &url)
+		var fullText, dead bool
+		var favicon string
+		err = feeds.Scan(&id, &title, &url, &fullText, &dead, &favicon)
 		if err != nil{
 			log.Fatal(err)
 		}
-		feed := FeedieFeed{Title: title, Url: url}
+		feed := FeedieFeed{ID: id, Title: title, Url: url, FullText: fullText, Dead: dead, FaviconURL: favicon}
 		if withEntries{
 			dbMu.RUnlock()
-			ents := DBGetByFeedTimeOrdered(feed, DESC, -1, 0);
+			ents := DBGetByFeedTimeOrdered(feed, DESC, allEntries);
 			dbMu.RLock()
 			feed.Entries = ents
 		}
@@ -411,6 +685,7 @@ func DBGetFeeds(withEntries bool ) []FeedieFeed{
 	return ret
 }
 func DBGetTags() []string{
+	defer observeQuery("tags")()
 	ret := []string{}
 	query := `SELECT name FROM tags`
 	dbMu.RLock()
@@ -431,6 +706,76 @@ func DBGetTags() []string{
 	return ret
 }
 
+type FeedieTag struct{
+	ID int64
+	Title string
+	// shared through the /feeds/tag/ output endpoints
+	Public bool
+}
+
+func DBGetTagList() []FeedieTag{
+	defer observeQuery("tags")()
+	ret := []FeedieTag{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	tags, err := db.Query(`SELECT id, name, public FROM tags ORDER BY id`)
+	if err != nil{
+		log.Fatal(err)
+	}
+	defer tags.Close()
+	for tags.Next() {
+		var t FeedieTag
+		if err := tags.Scan(&t.ID, &t.Title, &t.Public); err != nil{
+			log.Fatal(err)
+		}
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+func DBGetPublicTags() []string{
+	ret := []string{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	tags, err := db.Query(`SELECT name FROM tags WHERE public = 1`)
+	if err != nil{
+		log.Fatal(err)
+	}
+	defer tags.Close()
+	for tags.Next() {
+		var tag string
+		if err := tags.Scan(&tag); err != nil{
+			log.Fatal(err)
+		}
+		ret = append(ret, tag)
+	}
+	return ret
+}
+
+// DBGetTagPublic reports whether tag exists and is shared through the
+// /feeds/tag/ output endpoints.
+func DBGetTagPublic(tag string) (exists bool, public bool) {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT public FROM tags WHERE name = ?`, tag).Scan(&public)
+	if err != nil{
+		if err == sql.ErrNoRows{
+			return false, false
+		}
+		log.Fatal(err)
+	}
+	return true, public
+}
+
+func DBSetTagPublic(tag string, public bool) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`UPDATE tags SET public = ? WHERE name = ?`, public, tag)
+	if err != nil{
+		log.Fatal(err)
+	}
+}
+
 func DBDelTag(tag string) {
 	dbMu.Lock()
 	defer dbMu.Unlock()
@@ -439,17 +784,18 @@ func DBDelTag(tag string) {
 	if err != nil{
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 
 func DBDelFeed(feedURL string) {
 	dbMu.Lock()
 	defer dbMu.Unlock()
-	id := GetHashString(feedURL)
 	_, err := db.Exec(`
-	DELETE FROM feeds WHERE id = ?`, id)
+	DELETE FROM feeds WHERE url = ?`, feedURL)
 	if err != nil{
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 func DBClearMembersTag(tagName string) {
 	dbMu.Lock()
@@ -462,6 +808,7 @@ func DBClearMembersTag(tagName string) {
 	if err != nil{
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 func DBDelMembership(tagName, feedURL string) {
 	dbMu.Lock()
@@ -475,11 +822,12 @@ func DBDelMembership(tagName, feedURL string) {
 	if err != nil{
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 func DBAddMembership(tagName, feedURL string) {
 	dbMu.Lock()
 	defer dbMu.Unlock()
-	_, err := db.Exec(`INSERT INTO tag_members (tag_id, feed_id)
+	_, err := db.Exec(`INSERT OR IGNORE INTO tag_members (tag_id, feed_id)
 	VALUES (
 		(SELECT id FROM tags WHERE name = ? LIMIT 1),
 		(SELECT id FROM feeds WHERE url = ? LIMIT 1)
@@ -487,15 +835,17 @@ func DBAddMembership(tagName, feedURL string) {
 	if err != nil{
 		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
 // inverted refers to the query being "inverted" i.e. all feeds not in tag
 func DBGetFeedsByTag(tagName string, inverted bool) []FeedieFeed {
+	defer observeQuery("feeds_by_tag")()
 	dbMu.RLock()
 	defer dbMu.RUnlock()
 	ret := []FeedieFeed{}
 	var query string
 	if inverted{
-		query = `SELECT f.title, f.url
+		query = `SELECT f.id, f.title, f.url, f.dead
 		FROM feeds f
 		WHERE NOT EXISTS (
 			SELECT 1
@@ -506,7 +856,7 @@ func DBGetFeedsByTag(tagName string, inverted bool) []FeedieFeed {
 		);
 		`
 	} else{
-		query = `SELECT f.title, f.url
+		query = `SELECT f.id, f.title, f.url, f.dead
 		FROM feeds f
 		JOIN tag_members tm ON f.id = tm.feed_id
 		JOIN tags t ON tm.tag_id = t.id
@@ -519,18 +869,109 @@ func DBGetFeedsByTag(tagName string, inverted bool) []FeedieFeed {
 	}
 	defer feeds.Close()
 	for feeds.Next() {
+		var id int64
 		var title, url string
-		err = feeds.Scan(&title, &url)
+		var dead bool
+		err = feeds.Scan(&id, &title, &url, &dead)
 		if err != nil{
 			log.Fatal(err)
 		}
-		feed := FeedieFeed{Title: title, Url: url}
+		feed := FeedieFeed{ID: id, Title: title, Url: url, Dead: dead}
 		ret = append(ret, feed)
 	}
 	return ret
 }
+// shutDownDB closes the database once the transaction in progress, if any,
+// has committed.
 func shutDownDB() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
 	if db != nil{
-		db.Close()
+		watchDatabase(nil)
+		if err := db.Close(); err != nil {
+			slog.Error("unable to close database", "err", err)
+		}
+	}
+}
+
+// DBVacuum rebuilds the database file to reclaim the space of deleted rows.
+func DBVacuum() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// DBCheck runs SQLite's integrity and foreign key checks and returns the
+// problems they report.
+func DBCheck() []string {
+	problems := []string{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			log.Fatal(err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fk int
+		if err := rows.Scan(&table, &rowid, &parent, &fk); err != nil {
+			log.Fatal(err)
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d references a missing %s", table, rowid.Int64, parent))
+	}
+	return problems
+}
+
+func DBEntryExists(entryID int64) bool {
+	var exists bool
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM entries WHERE id = ?`, entryID).Scan(&exists)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return exists
+}
+
+func DBFeedExists(feedURL string) bool {
+	var exists bool
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT COUNT(*) > 0 FROM feeds WHERE url = ?`, feedURL).Scan(&exists)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return exists
+}
+
+func DBSetRead(entryID int64, read bool) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`UPDATE entries SET read = ? WHERE id = ?`, read, entryID)
+	if err != nil {
+		log.Fatal(err)
 	}
+	invalidateEntryCache()
 }
@@ -4,13 +4,32 @@ import "fmt"
 
 
 type FeedieEntry struct {
+	ID int64
+	// entryHash of the entry. Entries stored before ids were integers keep
+	// the hash of their GUID alone, which was their id.
+	hash string
 	Title string
 	Author string
 	Published int64
+	// Published is the time the entry was fetched, its feed giving no date
+	// that could be read
+	undated bool
 	Description string
 	Thumbnail string
 	Links []FeedieLink
 	GUID string
+	Read bool
+	Starred bool
+	// unix time the entry was first fetched, 0 if before that was recorded
+	FirstSeen int64
+	// the feed the entry is from
+	FeedID int64
+	FeedTitle string
+	FaviconURL string
+	// readable article extracted from the entry's page, only sent by /get_entry
+	Article string `json:",omitempty"`
+	Podcast *FeediePodcast `json:",omitempty"`
+	Video *FeedieVideo `json:",omitempty"`
 }
 
 func (e FeedieEntry) getHashString() string{
@@ -20,6 +39,13 @@ func (e FeedieEntry) getHashString() string{
 	return e.Title + e.Author + fmt.Sprintf("%d",e.Published)
 }
 
+// entryHash identifies the entry with key, its getHashString, within the
+// feed with id feedID. Feeds may use the same GUIDs, numeric post ids for
+// instance, so the key alone doesn't.
+func entryHash(feedID int64, key string) string{
+	return GetHashString(fmt.Sprintf("%d\n%s", feedID, key))
+}
+
 func newEntry (title string, author string, published int64, description string, thumbnail string) *FeedieEntry{
 	return &FeedieEntry{
 		Title: title,
@@ -49,3 +75,28 @@ type FeedieLink struct {
 	URL string
 	Type string
 }
+
+// FeediePodcast holds the episode metadata of entries from podcast feeds,
+// and the playback position last reported by a client.
+type FeediePodcast struct {
+	Duration int64 // seconds
+	Size int64 // bytes, of the first enclosure
+	Episode int
+	Season int
+	ChaptersURL string
+	Chapters []FeedieChapter
+	Position int64 // seconds
+}
+
+type FeedieChapter struct {
+	Start int64 // seconds
+	Title string
+}
+
+// FeedieVideo holds the statistics video feeds publish through Media RSS.
+type FeedieVideo struct {
+	Views int64
+	RatingAverage float64
+	RatingCount int64
+	Duration int64 // seconds
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// numberedFeed returns a feed whose entries have the GUIDs 1, 2, ... like
+// feeds using their post ids, with titles and links of their own.
+func numberedFeed(host string, published int64, count int) FeedieFeed {
+	entries := []FeedieEntry{}
+	for i := 1; i <= count; i++ {
+		e := newEntry(host+" post", "author", published+int64(i), "", "")
+		e.GUID = strconv.Itoa(i)
+		e.Links = append(e.Links, FeedieLink{URL: "http://" + host + "/" + e.GUID, Type: "text/html"})
+		entries = append(entries, *e)
+	}
+	return *newFeed(host, "http://"+host+"/feed", entries)
+}
+
+func TestFeedsSharingGUIDsKeepTheirEntries(t *testing.T) {
+	initTestDB(t)
+	a := numberedFeed("a.example.com", 1000, 2)
+	b := numberedFeed("b.example.com", time.Now().Unix(), 2)
+	DBAddFeedWithEntries(a)
+	DBAddFeedWithEntries(b)
+	DBAddFeedWithEntries(a)
+
+	for _, feed := range []FeedieFeed{a, b} {
+		entries := DBGetByFeedTimeOrdered(feed, DESC, allEntries)
+		if len(entries) != 2 {
+			t.Fatalf("%s has %d entries, want 2", feed.Title, len(entries))
+		}
+		for _, e := range entries {
+			if e.Title != feed.Title+" post" || len(e.Links) != 1 || e.Links[0].URL != "http://"+feed.Title+"/"+e.GUID {
+				t.Errorf("%s has entry %+v", feed.Title, e)
+			}
+		}
+	}
+	DBSetRead(DBGetEntryIDByLink("http://a.example.com/1"), true)
+	for _, e := range DBGetByFeedTimeOrdered(b, DESC, allEntries) {
+		if e.Read {
+			t.Error("marking an entry read marked the other feed's entry with its GUID")
+		}
+	}
+
+	// purging the old feed's entries doesn't keep the other's out
+	if n := DBPurgeEntries(RetentionConfig{MaxAgeDays: 1}); n != 2 {
+		t.Fatalf("purged %d entries, want 2", n)
+	}
+	DBAddFeedWithEntries(a)
+	DBAddFeedWithEntries(b)
+	if entries := DBGetByFeedTimeOrdered(a, DESC, allEntries); len(entries) != 0 {
+		t.Errorf("purged entries came back: %+v", entries)
+	}
+	if entries := DBGetByFeedTimeOrdered(b, DESC, allEntries); len(entries) != 2 {
+		t.Errorf("%d entries left in the other feed, want 2", len(entries))
+	}
+	c := numberedFeed("c.example.com", time.Now().Unix(), 2)
+	DBAddFeedWithEntries(c)
+	if entries := DBGetByFeedTimeOrdered(c, DESC, allEntries); len(entries) != 2 {
+		t.Errorf("%d entries added to a new feed, want 2", len(entries))
+	}
+}
+
+func TestMigrateSplitGUID(t *testing.T) {
+	initTestDB(t)
+	a := numberedFeed("a.example.com", 1000, 0)
+	DBAddFeedWithEntries(a)
+	// what was left of an entry both feeds had, after b refreshed last
+	b := numberedFeed("b.example.com", 1000, 1)
+	b.Entries[0].Links = append(b.Entries[0].Links, FeedieLink{URL: "http://a.example.com/1", Type: "text/html"})
+	// unique GUIDs are left alone
+	unique := newEntry("unique", "author", 1000, "", "")
+	unique.GUID = "http://b.example.com/unique"
+	unique.Links = []FeedieLink{{URL: "http://b.example.com/u"}, {URL: "http://a.example.com/u"}}
+	b.Entries = append(b.Entries, *unique)
+	DBAddFeedWithEntries(b)
+	var merged FeedieEntry
+	for _, e := range DBGetByFeedTimeOrdered(b, DESC, allEntries) {
+		if e.GUID == "1" {
+			merged = e
+		}
+	}
+	DBSetRead(merged.ID, true)
+	if _, err := db.Exec(`UPDATE entries SET starred = 1 WHERE id = ?`, merged.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateSplitGUID()
+
+	entries := DBGetByFeedTimeOrdered(a, DESC, allEntries)
+	if len(entries) != 1 || entries[0].GUID != "1" || len(entries[0].Links) != 1 ||
+		entries[0].Links[0].URL != "http://a.example.com/1" {
+		t.Fatalf("entries of the feed split off: %+v", entries)
+	}
+	// the entry was read and starred before it was split
+	if e := entries[0]; !e.Read || !e.Starred || e.FirstSeen != merged.FirstSeen {
+		t.Errorf("split off entry read %v, starred %v, first seen %d, want %d",
+			e.Read, e.Starred, e.FirstSeen, merged.FirstSeen)
+	}
+	for _, e := range DBGetByFeedTimeOrdered(b, DESC, allEntries) {
+		want := 1
+		if e.GUID == unique.GUID {
+			want = 2
+		}
+		if len(e.Links) != want {
+			t.Errorf("entry %s kept links %+v", e.GUID, e.Links)
+		}
+	}
+
+	// refreshing fills in the split off entry
+	DBAddFeedWithEntries(numberedFeed("a.example.com", 1000, 1))
+	entries = DBGetByFeedTimeOrdered(a, DESC, allEntries)
+	if len(entries) != 1 || entries[0].Title != "a.example.com post" {
+		t.Errorf("entries after refreshing: %+v", entries)
+	}
+}
+
+func TestEntriesNameTheirFeed(t *testing.T) {
+	initTestDB(t)
+	a := numberedFeed("a.example.com", 1000, 1)
+	a.Entries[0].Description = "<p>full text</p>"
+	DBAddFeedWithEntries(a)
+	DBAddFeedWithEntries(numberedFeed("b.example.com", 1000, 1))
+
+	entries := DBGetAllTimeOrdered(DESC, allEntries)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		site := "http://" + e.FeedTitle
+		if e.ID == 0 || e.FeedID == 0 || e.Links[0].URL != site+"/1" || e.FaviconURL != site+"/favicon.ico" {
+			t.Errorf("entry %+v", e)
+		}
+	}
+
+	id := DBGetEntryIDByLink("http://a.example.com/1")
+	rec := httptest.NewRecorder()
+	getEntryHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entry?id="+fmt.Sprint(id), nil))
+	var got FeedieEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.Description != "<p>full text</p>" || got.FeedTitle != "a.example.com" {
+		t.Errorf("/get_entry returned %+v", got)
+	}
+	rec = httptest.NewRecorder()
+	getEntryHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entry?id=999", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("/get_entry of a missing entry answered %d", rec.Code)
+	}
+}
+
+func TestPagesHoldWholeEntries(t *testing.T) {
+	initTestDB(t)
+	// the nth entry has n links, more than a page's worth for most
+	feed := numberedFeed("a.example.com", 1000, 7)
+	for i := range feed.Entries {
+		e := &feed.Entries[i]
+		for k := 1; k <= i; k++ {
+			e.Links = append(e.Links, FeedieLink{URL: fmt.Sprintf("http://a.example.com/%s/%d", e.GUID, k)})
+		}
+	}
+	DBAddTag("news")
+	DBAddFeedWithEntries(feed)
+	DBAddMembership("news", feed.Url)
+
+	queries := map[string]func(entryPage) []FeedieEntry{
+		"all":     func(p entryPage) []FeedieEntry { return DBGetAllTimeOrdered(DESC, p) },
+		"by_tag":  func(p entryPage) []FeedieEntry { return DBGetByTagTimeOrdered("news", DESC, p) },
+		"by_feed": func(p entryPage) []FeedieEntry { return DBGetByFeedTimeOrdered(feed, DESC, p) },
+		"search":  func(p entryPage) []FeedieEntry { return DBSearchTimeOrdered("post", DESC, p) },
+	}
+	const limit = 3
+	for name, query := range queries {
+		check := func(how string, page []FeedieEntry, want int) {
+			t.Helper()
+			if len(page) != want {
+				t.Errorf("%s page %s has %d entries, want %d", name, how, len(page), want)
+			}
+			for _, e := range page {
+				if n := int(e.Published - 1000); len(e.Links) != n {
+					t.Errorf("%s page %s has entry %s with %d links, want %d", name, how, e.GUID, len(e.Links), n)
+				}
+			}
+		}
+
+		seen := map[int64]bool{}
+		for offset := 0; offset < len(feed.Entries); offset += limit {
+			page := query(entryPage{Limit: limit, Offset: offset})
+			check(fmt.Sprint("at ", offset), page, min(limit, len(feed.Entries)-offset))
+			for _, e := range page {
+				seen[e.ID] = true
+			}
+		}
+		if len(seen) != len(feed.Entries) {
+			t.Errorf("%s pages hold %d entries, want %d", name, len(seen), len(feed.Entries))
+		}
+
+		first := query(entryPage{Limit: limit})
+		cursor := cursorOf(first[len(first)-1], defaultEntrySort)
+		check("after a cursor", query(entryPage{Limit: limit, Cursor: &cursor}), limit)
+		cursor = cursorOf(first[0], defaultEntrySort)
+		check("before the first", query(entryPage{Limit: limit, Cursor: &cursor, Backward: true}), 0)
+		check("at the end", query(entryPage{Limit: limit, Backward: true}), limit)
+	}
+}
+
+func TestCorrectedDatesAreUpdated(t *testing.T) {
+	initTestDB(t)
+	feed := testFeed("http://a.example.com/feed", "dated", "undated")
+	DBAddFeedWithEntries(feed)
+
+	feed.Entries[0].Published += 3600
+	feed.Entries[1].Published += 3600
+	feed.Entries[1].undated = true
+	DBAddFeedWithEntries(feed)
+	for _, e := range DBGetByFeedTimeOrdered(feed, ASC, allEntries) {
+		want := int64(1000 + 3600)
+		if e.Title == "undated" {
+			want = 1001
+		}
+		if e.Published != want {
+			t.Errorf("%q was published at %d after refreshing, want %d", e.Title, e.Published, want)
+		}
+	}
+}
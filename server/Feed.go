@@ -1,9 +1,22 @@
 package main
 
+import "net/url"
+
 type FeedieFeed struct{
+	ID int64
 	Title string
 	Url string
 	Entries []FeedieEntry
+	FullText bool
+	// favicon of the site the feed belongs to, by convention
+	FaviconURL string
+	// stopped refreshing after the feed answered 410 Gone
+	Dead bool
+	// WebSub hub and topic advertised by the feed, if any
+	hub string
+	topic string
+	// url the feed was permanently redirected to when fetched, if any
+	movedTo string
 }
 
 func newFeed(title string, url string, entries []FeedieEntry) *FeedieFeed{
@@ -11,5 +24,16 @@ func newFeed(title string, url string, entries []FeedieEntry) *FeedieFeed{
 		Title: title,
 		Url: url,
 		Entries: entries,
+		FaviconURL: faviconURL(url),
+	}
+}
+
+// faviconURL returns the conventional favicon location of the site at
+// siteURL, or "" if it isn't a web address.
+func faviconURL(siteURL string) string{
+	u, err := url.Parse(siteURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == ""{
+		return ""
 	}
+	return u.Scheme + "://" + u.Host + "/favicon.ico"
 }
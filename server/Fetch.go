@@ -0,0 +1,241 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Feeds, pages and articles are all fetched through one Fetcher: a shared
+// http.Client with the configured timeout, proxy and User-Agent, a cap on
+// redirects and on the decoded body size, gzip, deflate and brotli decoding
+// and a limit on concurrent requests to any one host, so a slow publisher
+// can't hold every refresh worker.
+
+var errBodyTooLarge = errors.New("response body too large")
+
+// StatusError is returned for responses other than 200 OK.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+	maxBody   int64
+	perHost   int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// httpFetcher is the fetcher configured by configureHTTP.
+var httpFetcher = NewFetcher(getDefaultServerConf())
+
+func NewFetcher(conf FeedieServerConfig) *Fetcher {
+	proxy := http.ProxyFromEnvironment
+	if conf.Proxy != "" {
+		proxyURL, _ := url.Parse(conf.Proxy)
+		proxy = http.ProxyURL(proxyURL)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: time.Duration(conf.Timeouts.Fetch),
+		// Accept-Encoding is set by Get, which decodes the body itself
+		DisableCompression: true,
+	}
+
+	maxRedirects := conf.Fetch.MaxRedirects
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(conf.Timeouts.Fetch),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return nil
+			},
+		},
+		userAgent: conf.UserAgent,
+		maxBody:   conf.Fetch.MaxBody,
+		perHost:   conf.Fetch.PerHost,
+		hosts:     map[string]chan struct{}{},
+	}
+}
+
+// hostSlots returns the semaphore limiting requests to host.
+func (f *Fetcher) hostSlots(host string) chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	slots, ok := f.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, f.perHost)
+		f.hosts[host] = slots
+	}
+	return slots
+}
+
+// Get fetches rawURL with the extra request headers given. The response is
+// returned only for 200 OK, other statuses are a *StatusError. Its body is
+// decoded and fails with errBodyTooLarge past the size limit; closing it
+// frees the host's slot for the next request.
+func (f *Fetcher) Get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	slots := f.hostSlots(req.URL.Host)
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := sync.OnceFunc(func() { <-slots })
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		release()
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := decodeBody(resp)
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+	resp.Body = &fetchBody{
+		Reader:  &limitedReader{r: body, left: f.maxBody},
+		closers: []io.Closer{body, resp.Body},
+		release: release,
+	}
+	return resp, nil
+}
+
+// decodeBody undoes the response's Content-Encoding.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		body, err = zlib.NewReader(resp.Body)
+	case "br":
+		body = io.NopCloser(brotli.NewReader(resp.Body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return body, nil
+}
+
+// limitedReader fails instead of truncating once more than left bytes
+// have been read.
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+type fetchBody struct {
+	io.Reader
+	closers []io.Closer
+	release func()
+}
+
+func (b *fetchBody) Close() error {
+	var err error
+	for _, c := range b.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	b.release()
+	return err
+}
+
+// permanentRedirect returns the url a response's request was permanently
+// moved to: where the redirects led while they were all 301 or 308, or ""
+// if the first one was temporary or there were none.
+func permanentRedirect(resp *http.Response) string {
+	// each redirected request keeps the response that caused it, so the
+	// chain is walked back from the last request
+	var hops []*http.Request
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hops = append(hops, req)
+	}
+	moved := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		code := hops[i].Response.StatusCode
+		if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			break
+		}
+		moved = hops[i].URL.String()
+	}
+	return moved
+}
+
+// fetchPage fetches pageURL with the shared fetcher, giving up when the
+// server shuts down.
+func fetchPage(pageURL string) (*http.Response, error) {
+	return httpFetcher.Get(serverContext(), pageURL, nil)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func testFetcher(t *testing.T, edit func(*FeedieServerConfig)) *Fetcher {
+	t.Helper()
+	conf := getDefaultServerConf()
+	if edit != nil {
+		edit(&conf)
+	}
+	return NewFetcher(conf)
+}
+
+func readAll(t *testing.T, f *Fetcher, url string) (string, error) {
+	t.Helper()
+	resp, err := f.Get(context.Background(), url, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestFetchDecodesCompressedBodies(t *testing.T) {
+	const doc = "<rss><channel><title>compressed</title></channel></rss>"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		switch r.URL.Path {
+		case "/gzip":
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(doc))
+			zw.Close()
+		case "/br":
+			bw := brotli.NewWriter(&buf)
+			bw.Write([]byte(doc))
+			bw.Close()
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), strings.TrimPrefix(r.URL.Path, "/")) {
+			t.Errorf("Accept-Encoding %q lacks %s", r.Header.Get("Accept-Encoding"), r.URL.Path)
+		}
+		w.Header().Set("Content-Encoding", strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	f := testFetcher(t, nil)
+	for _, path := range []string{"/gzip", "/br"} {
+		body, err := readAll(t, f, srv.URL+path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if body != doc {
+			t.Errorf("%s: got %q", path, body)
+		}
+	}
+}
+
+func TestFetchLimitsBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// compresses well, so the limit must apply after decoding
+		zw := gzip.NewWriter(w)
+		w.Header().Set("Content-Encoding", "gzip")
+		zw.Write(bytes.Repeat([]byte("a"), 4096))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	small := testFetcher(t, func(c *FeedieServerConfig) { c.Fetch.MaxBody = 1024 })
+	if _, err := readAll(t, small, srv.URL); !errors.Is(err, errBodyTooLarge) {
+		t.Errorf("got %v, want errBodyTooLarge", err)
+	}
+	exact := testFetcher(t, func(c *FeedieServerConfig) { c.Fetch.MaxBody = 4096 })
+	if body, err := readAll(t, exact, srv.URL); err != nil || len(body) != 4096 {
+		t.Errorf("got %d bytes and %v, want the whole body", len(body), err)
+	}
+}
+
+func TestFetchLimitsRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /3 redirects to /2, /1 and then /0, which answers
+		n := strings.TrimPrefix(r.URL.Path, "/")
+		if n == "0" {
+			w.Write([]byte("landed"))
+			return
+		}
+		http.Redirect(w, r, "/"+string(rune(n[0]-1)), http.StatusFound)
+	}))
+	defer srv.Close()
+
+	f := testFetcher(t, func(c *FeedieServerConfig) { c.Fetch.MaxRedirects = 2 })
+	if body, err := readAll(t, f, srv.URL+"/2"); err != nil || body != "landed" {
+		t.Errorf("two redirects: got %q and %v", body, err)
+	}
+	if _, err := readAll(t, f, srv.URL+"/3"); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("three redirects: got %v, want a redirect limit error", err)
+	}
+}
+
+func TestFetchTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	f := testFetcher(t, func(c *FeedieServerConfig) { c.Timeouts.Fetch = Duration(100 * time.Millisecond) })
+	start := time.Now()
+	if _, err := readAll(t, f, srv.URL); err == nil {
+		t.Fatal("a hanging server did not time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timing out took %s", elapsed)
+	}
+}
+
+func TestFetchCancelledWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	f := testFetcher(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := f.Get(ctx, srv.URL, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
+
+func TestFetchSetsUserAgentAndReportsStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "FeedieTest/2" {
+			t.Errorf("User-Agent %q", ua)
+		}
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer srv.Close()
+
+	f := testFetcher(t, func(c *FeedieServerConfig) { c.UserAgent = "FeedieTest/2" })
+	_, err := readAll(t, f, srv.URL)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusGone {
+		t.Errorf("got %v, want a 410 StatusError", err)
+	}
+}
+
+func TestFetchLimitsRequestsPerHost(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	f := testFetcher(t, func(c *FeedieServerConfig) { c.Fetch.PerHost = 2 })
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := readAll(t, f, srv.URL); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most != 2 {
+		t.Errorf("%d requests ran at once, want 2", most)
+	}
+}
+
+func TestFetchUsesProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy is sent the absolute url of the feed
+		if r.URL.Host != "feeds.example.invalid" {
+			t.Errorf("proxy asked for host %q", r.URL.Host)
+		}
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	f := testFetcher(t, func(c *FeedieServerConfig) { c.Proxy = proxy.URL })
+	if body, err := readAll(t, f, "http://feeds.example.invalid/feed.xml"); err != nil || body != "via proxy" {
+		t.Errorf("got %q and %v", body, err)
+	}
+}
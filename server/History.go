@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A feed's history records what happened to it beyond its entries: moving
+// to a new url after a permanent redirect, going away with 410 Gone and
+// coming back when it was added again.
+
+const (
+	feedEventMoved   = "moved"
+	feedEventGone    = "gone"
+	feedEventRevived = "revived"
+)
+
+type FeedieFeedEvent struct {
+	Time   int64
+	Event  string
+	Detail string
+}
+
+// addFeedEvent records an event for the feed with id feedID in tx.
+func addFeedEvent(tx *sql.Tx, feedID int64, event, detail string) {
+	_, err := tx.Exec(`INSERT INTO feed_history (feed_id, time, event, detail)
+VALUES (?, ?, ?, ?)`, feedID, time.Now().Unix(), event, detail)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+}
+
+func DBGetFeedHistory(feedURL string, limit int) []FeedieFeedEvent {
+	ret := []FeedieFeedEvent{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT time, event, detail
+FROM feed_history
+WHERE feed_id = (SELECT id FROM feeds WHERE url = ?)
+ORDER BY id DESC
+LIMIT ?`, feedURL, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e FeedieFeedEvent
+		if err := rows.Scan(&e.Time, &e.Event, &e.Detail); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+// DBSetFeedDead stops refreshing a feed that answered 410 Gone with status.
+// Adding the feed again brings it back.
+func DBSetFeedDead(feedURL, status string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var feedID int64
+	err = tx.QueryRow(`UPDATE feeds SET dead = 1 WHERE url = ? AND dead = 0 RETURNING id`, feedURL).Scan(&feedID)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	if err == nil {
+		addFeedEvent(tx, feedID, feedEventGone, status)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// DBMoveFeed moves a feed to newURL, keeping its id and so its entries, tag
+// memberships and history, and points the rules and webhooks scoped to its
+// url at the new one. If newURL is already subscribed to the two are merged
+// into the existing feed.
+func DBMoveFeed(oldURL, newURL string) {
+	defer observeQuery("move_feed")()
+	if oldURL == newURL {
+		return
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var oldID, newID int64
+	err = tx.QueryRow(`SELECT
+COALESCE((SELECT id FROM feeds WHERE url = ?), 0),
+COALESCE((SELECT id FROM feeds WHERE url = ?), 0)`, oldURL, newURL).Scan(&oldID, &newID)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	// deleted while it was being fetched
+	if oldID == 0 {
+		tx.Rollback()
+		return
+	}
+	type statement struct {
+		query string
+		args  []any
+	}
+	statements := []statement{
+		{`UPDATE rules SET scope_value = ? WHERE scope = 'feed' AND scope_value = ?`, []any{newURL, oldURL}},
+		{`UPDATE webhooks SET scope_value = ? WHERE scope = 'feed' AND scope_value = ?`, []any{newURL, oldURL}},
+	}
+	if newID == 0 {
+		newID = oldID
+		statements = append(statements,
+			statement{`UPDATE feeds SET url = ?, hash = ? WHERE id = ?`, []any{newURL, GetHashString(newURL), oldID}},
+			// the WebSub subscription was for the old url
+			statement{`DELETE FROM websub WHERE feed_id = ?`, []any{oldID}},
+		)
+	} else {
+		statements = append(statements,
+			// entries both feeds have stay with the new one
+			statement{`UPDATE OR IGNORE entries SET feed_id = ? WHERE feed_id = ?`, []any{newID, oldID}},
+			statement{`INSERT OR IGNORE INTO tag_members (tag_id, feed_id)
+SELECT tag_id, ? FROM tag_members WHERE feed_id = ?`, []any{newID, oldID}},
+			statement{`INSERT OR IGNORE INTO feed_sources (feed_id, adapter, config)
+SELECT ?, adapter, config FROM feed_sources WHERE feed_id = ?`, []any{newID, oldID}},
+			statement{`UPDATE feed_history SET feed_id = ? WHERE feed_id = ?`, []any{newID, oldID}},
+			statement{`UPDATE OR IGNORE purged_entries SET feed_id = ? WHERE feed_id = ?`, []any{newID, oldID}},
+			statement{`DELETE FROM feeds WHERE id = ?`, []any{oldID}},
+		)
+	}
+	for _, s := range statements {
+		if _, err := tx.Exec(s.query, s.args...); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	addFeedEvent(tx, newID, feedEventMoved, oldURL+" -> "+newURL)
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	invalidateEntryCache()
+}
+
+func getFeedHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	feedURL := r.URL.Query().Get("feed_url")
+	if feedURL == "" {
+		http.Error(w, "feed_url value empty", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, DBGetFeedHistory(feedURL, limit))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRefreshFollowsPermanentRedirect(t *testing.T) {
+	initTestDB(t)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			fmt.Fprintf(w, `<rss version="2.0"><channel><title>Moved</title>
+<item><title>a</title><guid>%[1]s/old#a</guid><link>%[1]s/old/a</link></item>
+<item><title>b</title><guid>%[1]s/new#b</guid><link>%[1]s/new/b</link></item>
+</channel></rss>`, srv.URL)
+		}
+	}))
+	defer srv.Close()
+	oldURL, newURL := srv.URL+"/old", srv.URL+"/new"
+
+	DBAddFeedWithEntries(testFeed(oldURL, "a"))
+	DBAddTag("news")
+	DBAddMembership("news", oldURL)
+	id := DBGetEntryIDByLink(oldURL + "/a")
+	if id == 0 {
+		t.Fatal("the entry was not added")
+	}
+	DBSetRead(id, true)
+	if _, err := DBAddRule(FeedieRule{Field: "title", MatchType: "regex", Pattern: "x",
+		Scope: ruleScopeFeed, ScopeValue: oldURL, Action: ruleActionHide}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !refreshFeed(oldURL) {
+		t.Fatal("refresh failed")
+	}
+
+	feeds := DBGetFeeds(false)
+	if len(feeds) != 1 || feeds[0].Url != newURL {
+		t.Fatalf("feeds after the move: %+v", feeds)
+	}
+	if tagged := DBGetFeedsByTag("news", false); len(tagged) != 1 || tagged[0].Url != newURL {
+		t.Errorf("tag members after the move: %+v", tagged)
+	}
+	entries := DBGetByFeedTimeOrdered(feeds[0], DESC, allEntries)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries after the move, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.Title == "a" && !e.Read {
+			t.Error("the entry read before the move is unread")
+		}
+	}
+	if rules := DBGetRules(); len(rules) != 1 || rules[0].ScopeValue != newURL {
+		t.Errorf("rules after the move: %+v", rules)
+	}
+	history := DBGetFeedHistory(newURL, 10)
+	if len(history) != 1 || history[0].Event != feedEventMoved {
+		t.Errorf("history after the move: %+v", history)
+	}
+}
+
+func TestRefreshMarksGoneFeedDead(t *testing.T) {
+	initTestDB(t)
+	var gone atomic.Bool
+	gone.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gone.Load() {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		w.Write([]byte(`<rss version="2.0"><channel><title>Back</title></channel></rss>`))
+	}))
+	defer srv.Close()
+
+	DBAddFeedWithEntries(testFeed(srv.URL, "a"))
+	if refreshFeed(srv.URL) {
+		t.Fatal("refreshing a gone feed succeeded")
+	}
+	refreshFeed(srv.URL)
+	if feeds := DBGetFeeds(false); len(feeds) != 1 || !feeds[0].Dead {
+		t.Fatalf("feeds after 410: %+v", feeds)
+	}
+
+	gone.Store(false)
+	if _, err := addFeed(FeedieSource{Url: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if feeds := DBGetFeeds(false); feeds[0].Dead {
+		t.Error("adding the feed again did not revive it")
+	}
+	history := DBGetFeedHistory(srv.URL, 10)
+	if len(history) != 2 || history[0].Event != feedEventRevived || history[1].Event != feedEventGone {
+		t.Errorf("history: %+v", history)
+	}
+}
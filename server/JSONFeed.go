@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// JSON Feed 1.1 output, see https://www.jsonfeed.org/version/1.1/
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+const defaultOutputLimit = 50
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+// primaryLink returns the entry's first text/html link.
+func (e FeedieEntry) primaryLink() string {
+	for _, l := range e.Links {
+		if l.Type == "text/html" {
+			return l.URL
+		}
+	}
+	return ""
+}
+
+// enclosures returns the entry's links that aren't web pages, i.e. the
+// enclosures stored by the parser.
+func (e FeedieEntry) enclosures() []FeedieLink {
+	ret := []FeedieLink{}
+	for _, l := range e.Links {
+		if l.Type != "text/html" && l.Type != "" {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+func newJSONFeed(title, feedURL string, entries []FeedieEntry) jsonFeed {
+	feed := jsonFeed{
+		Version: jsonFeedVersion,
+		Title:   title,
+		FeedURL: feedURL,
+		Items:   []jsonFeedItem{},
+	}
+	for _, e := range entries {
+		item := jsonFeedItem{
+			ID:          e.hash,
+			URL:         e.primaryLink(),
+			Title:       e.Title,
+			ContentHTML: e.Description,
+			Image:       e.Thumbnail,
+		}
+		if e.Published > 0 {
+			item.DatePublished = time.Unix(e.Published, 0).UTC().Format(time.RFC3339)
+		}
+		if e.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: e.Author}}
+		}
+		for _, l := range e.enclosures() {
+			item.Attachments = append(item.Attachments, jsonFeedAttachment{URL: l.URL, MimeType: l.Type})
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	return feed
+}
+
+// outputTitle names the aggregate feed generated for an entry query.
+func outputTitle(method, value string) string {
+	switch method {
+	case "by_tag":
+		return fmt.Sprintf("Feedie #%s", value)
+	case "by_feed":
+		return value
+	case "search":
+		return fmt.Sprintf("Feedie search: %s", value)
+	}
+	return "Feedie: All feeds"
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+}
+
+func jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	method := r.URL.Query().Get("method")
+	value := r.URL.Query().Get("value")
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = defaultOutputLimit
+	}
+
+	// tags are only re-published once shared, as on /feeds/tag/
+	if method == "by_tag" {
+		if exists, public := DBGetTagPublic(value); !exists || !public {
+			slog.WarnContext(r.Context(), "tag is not public", "tag", value)
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	entries, err := queryEntries(method, value, DESC, entryPage{Limit: limit})
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid entries query", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	feed := newJSONFeed(outputTitle(method, value), requestURL(r), entries)
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Background work, the refresh, maildir and WebSub loops and the jobs they
+// and the handlers start, runs under the server's context. Shutting down
+// cancels it, which stops the loops and aborts the fetches in flight, then
+// waits for the jobs to return so no transaction is cut short by closing the
+// database.
+
+// how long shutting down waits for requests and jobs before giving up
+const shutdownTimeout = 30 * time.Second
+
+// serverContext is cancelled when the server shuts down. Outside of serve,
+// in the one-shot commands, it is never cancelled.
+func serverContext() context.Context {
+	if feedieServer == nil || feedieServer.ctx == nil {
+		return context.Background()
+	}
+	return feedieServer.ctx
+}
+
+// goJob runs f in a goroutine that shutting down waits for.
+func goJob(f func()) {
+	if feedieServer == nil {
+		go f()
+		return
+	}
+	feedieServer.jobs.Add(1)
+	go func() {
+		defer feedieServer.jobs.Done()
+		f()
+	}()
+}
+
+// sleepCtx waits for d, reporting false if ctx was cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// serve runs the API on ln and the background loops until ctx is cancelled
+// or the listener fails, then shuts down: it stops accepting requests and
+// waits for those in flight, cancels the background jobs, waits for them and
+// for webhook deliveries to return and closes the database.
+func serve(ctx context.Context, conf FeedieServerConfig, ln net.Listener) error {
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	feedieServer.ctx = jobsCtx
+
+	goJob(func() { refreshThread(jobsCtx, conf.RefreshRate) })
+	if conf.Maildir != "" {
+		goJob(func() { mailThread(jobsCtx, conf.Maildir) })
+	}
+	if websubEnabled() {
+		goJob(func() { websubThread(jobsCtx) })
+	}
+
+	srv := newHTTPServer(conf)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	slog.Info("listening", "addr", ln.Addr().String())
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+	}
+	slog.Info("shutting down")
+	deadline, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := srv.Shutdown(deadline); shutdownErr != nil {
+		slog.Warn("requests still running at shutdown", "err", shutdownErr)
+	}
+	cancelJobs()
+	if !waitJobs(deadline) {
+		slog.Warn("background jobs still running at shutdown")
+	}
+	shutDownDB()
+	slog.Info("shut down")
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// waitJobs waits for the background jobs and webhook deliveries to return,
+// reporting false if ctx ended first.
+func waitJobs(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		feedieServer.jobs.Wait()
+		webhooksInFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
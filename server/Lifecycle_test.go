@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeShutsDownCleanly(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "feedie.db")
+	DBInit(dbPath)
+	conf := getDefaultServerConf()
+	conf.DBPath = dbPath
+	feedieServer = &FeedieServer{config: conf}
+	t.Cleanup(func() { feedieServer = nil })
+
+	// a feed that never answers, so the refresh is mid fetch at shutdown
+	fetching := make(chan struct{}, 1)
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case fetching <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hung.Close)
+	DBAddFeed(*newFeed("Hung", hung.URL, nil))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, conf, ln) }()
+
+	resp, err := http.Get(base + "/get_tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/get_tags responded %s", resp.Status)
+	}
+	select {
+	case <-fetching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the refresh did not start")
+	}
+
+	// a job still writing when shutdown begins gets to commit
+	goJob(func() {
+		<-serverContext().Done()
+		time.Sleep(50 * time.Millisecond)
+		DBAddFeedWithEntries(testFeed("http://late.example.com/feed", "late"))
+	})
+
+	stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve returned %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve did not return, the hung fetch was not cancelled")
+	}
+
+	if resp, err := http.Get(base + "/get_tags"); err == nil {
+		resp.Body.Close()
+		t.Error("the server still accepts requests")
+	}
+	if err := db.Ping(); err == nil {
+		t.Error("the database is still open")
+	}
+
+	DBInit(dbPath)
+	t.Cleanup(func() { db.Close() })
+	var entries int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("%d entries were committed, want 1", entries)
+	}
+}
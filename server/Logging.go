@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The server logs through log/slog, as text or JSON lines. Every API request
+// is logged once by logRequests with its id, status and duration; messages
+// logged with the request's context carry the same id.
+
+type contextKey int
+
+const requestIDKey contextKey = iota
+
+// maxRequestIDLen caps request ids taken from the X-Request-Id header
+const maxRequestIDLen = 64
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// contextHandler adds the id of the request being served to records logged
+// with its context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogging sends the default slog logger to the configured file in the
+// configured format. What is still logged through the log package, the
+// fatal database errors, is logged at error level.
+func setupLogging(conf FeedieServerConfig) {
+	var w io.Writer = os.Stderr
+	if conf.Log.File != "" {
+		if err := ensureParentDirs(conf.Log.File); err != nil {
+			log.Fatal(err)
+		}
+		logFile, err := os.OpenFile(conf.Log.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w = logFile
+	}
+	level, _ := parseLogLevel(conf.Log.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if conf.Log.Format == "json" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests gives every request an id, echoed in the X-Request-Id header,
+// then logs and records metrics for it once it has been served. A client
+// supplied X-Request-Id is kept so requests can be traced across services.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > maxRequestIDLen {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		duration := time.Since(start)
+		// the mux sets the pattern it matched, which keeps the metric labels
+		// bounded however many urls are requested
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		httpDuration.WithLabelValues(route).Observe(duration.Seconds())
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("query", redactQuery(r.URL.Query())),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", duration),
+			slog.Int("bytes", rec.bytes),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
+
+// redactQuery encodes a query string without the auth token.
+func redactQuery(q url.Values) string {
+	if q.Has("token") {
+		q.Set("token", "redacted")
+	}
+	return q.Encode()
+}
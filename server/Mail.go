@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// Newsletters are ingested from a Maildir, typically filled by fetchmail,
+// getmail or an MTA alias. Every message in new/ becomes an entry of a
+// synthetic feed keyed by its List-Id, or by its sender if it has none, and
+// is then moved to cur/ so it is only read once.
+
+const mailPollRate = 30 * time.Second
+
+// synthetic feed urls of newsletters; they aren't fetched on refresh
+const (
+	mailSenderPrefix = "mailto:"
+	mailListPrefix   = "list:"
+)
+
+var plainLinkRe = regexp.MustCompile(`https?://[^\s<>"')\]]+`)
+
+var headerDecoder = mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+func isMailFeed(feedURL string) bool {
+	return strings.HasPrefix(feedURL, mailSenderPrefix) || strings.HasPrefix(feedURL, mailListPrefix)
+}
+
+func mailThread(ctx context.Context, maildir string) {
+	for _, dir := range []string{"new", "cur", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(maildir, dir), 0700); err != nil {
+			slog.Error("unable to use maildir", "maildir", maildir, "err", err)
+			return
+		}
+	}
+	slog.Info("watching maildir", "maildir", maildir)
+	for {
+		scanMaildir(maildir)
+		if !sleepCtx(ctx, mailPollRate) {
+			return
+		}
+	}
+}
+
+func scanMaildir(maildir string) {
+	files, err := os.ReadDir(filepath.Join(maildir, "new"))
+	if err != nil {
+		slog.Error("unable to read maildir", "err", err)
+		return
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(maildir, "new", f.Name())
+		flags := ":2,S"
+		if err := ingestMessageFile(path); err != nil {
+			// moved anyway, an unreadable message won't get any better
+			slog.Warn("unable to ingest message", "path", path, "err", err)
+			flags = ":2,"
+		}
+		if err := os.Rename(path, filepath.Join(maildir, "cur", f.Name()+flags)); err != nil {
+			slog.Error("unable to move message to cur", "path", path, "err", err)
+		}
+	}
+}
+
+func ingestMessageFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	feed, err := parseMessage(file)
+	if err != nil {
+		return err
+	}
+	DBAddFeedWithEntries(*feed)
+	slog.Info("ingested newsletter", "feed", feed.Url)
+	return nil
+}
+
+// parseMessage turns a message into a single entry feed.
+func parseMessage(r io.Reader) (*FeedieFeed, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	header := msg.Header
+
+	parser := mail.AddressParser{WordDecoder: &headerDecoder}
+	from, err := parser.ParseList(header.Get("From"))
+	if err != nil || len(from) == 0 {
+		return nil, errors.New("message has no sender")
+	}
+	sender := from[0]
+	author := sender.Name
+	if author == "" {
+		author = sender.Address
+	}
+	// the feed is titled after what it's keyed by, senders often putting
+	// the issue or topic in their name
+	address := strings.ToLower(sender.Address)
+	feedTitle, feedURL := address, mailSenderPrefix+address
+	if listName, listID := parseListID(header.Get("List-Id")); listID != "" {
+		feedURL = mailListPrefix + strings.ToLower(listID)
+		feedTitle = listName
+		if feedTitle == "" {
+			feedTitle = listID
+		}
+	}
+
+	body, err := messageBody(header, msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := newEmptyEntry()
+	entry.Title = decodeHeader(header.Get("Subject"))
+	entry.Author = author
+	entry.GUID = strings.Trim(header.Get("Message-Id"), "<> ")
+	entry.Description = body
+	if date, err := header.Date(); err == nil {
+		entry.Published = date.Unix()
+	} else {
+		entry.Published, entry.undated = time.Now().Unix(), true
+	}
+	entry.Thumbnail, entry.Links = extractMailLinks(body)
+	return newFeed(feedTitle, feedURL, []FeedieEntry{*entry}), nil
+}
+
+// parseListID splits a List-Id header such as `Name <id.example.com>`.
+func parseListID(v string) (string, string) {
+	v = decodeHeader(v)
+	start, end := strings.LastIndex(v, "<"), strings.LastIndex(v, ">")
+	if start == -1 || end < start {
+		return "", strings.TrimSpace(v)
+	}
+	return strings.Trim(strings.TrimSpace(v[:start]), `"`), v[start+1 : end]
+}
+
+func decodeHeader(v string) string {
+	decoded, err := headerDecoder.DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// messageBody returns the HTML body of a message, preferring the text/html
+// alternative of multipart messages and converting plain text otherwise.
+func messageBody(header mail.Header, body io.Reader) (string, error) {
+	htmlBody, textBody, err := readPart(header.Get("Content-Type"), header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return "", err
+	}
+	if htmlBody != "" {
+		return htmlBody, nil
+	}
+	if textBody != "" {
+		text := html.EscapeString(strings.TrimSpace(textBody))
+		text = plainLinkRe.ReplaceAllStringFunc(text, func(link string) string {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, link, link)
+		})
+		return "<p>" + strings.ReplaceAll(text, "\n", "<br>") + "</p>", nil
+	}
+	return "", errors.New("message has no text body")
+}
+
+// readPart walks a MIME part and returns the first html and plain text
+// bodies found in it.
+func readPart(contentType, encoding string, body io.Reader) (string, string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		var htmlBody, textBody string
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", "", err
+			}
+			if strings.HasPrefix(part.Header.Get("Content-Disposition"), "attachment") {
+				continue
+			}
+			h, t, err := readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", "", err
+			}
+			if htmlBody == "" {
+				htmlBody = h
+			}
+			if textBody == "" {
+				textBody = t
+			}
+		}
+		return htmlBody, textBody, nil
+	}
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return "", "", nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+	}
+	if cs := params["charset"]; cs != "" {
+		if body, err = charset.NewReaderLabel(cs, body); err != nil {
+			return "", "", err
+		}
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", "", err
+	}
+	if mediaType == "text/html" {
+		return string(content), "", nil
+	}
+	return "", string(content), nil
+}
+
+// newlineStripper drops the line breaks base64 bodies are wrapped with.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		c = copy(p, bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, p[:c]))
+		if c > 0 || err != nil {
+			return c, err
+		}
+	}
+}
+
+// extractMailLinks returns the first real image of a newsletter as its
+// thumbnail, and its web links. Tracking pixels and mailto links are skipped.
+func extractMailLinks(body string) (string, []FeedieLink) {
+	links := []FeedieLink{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return "", links
+	}
+	seen := map[string]bool{}
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		href := strings.TrimSpace(a.AttrOr("href", ""))
+		if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") || seen[href] {
+			return
+		}
+		seen[href] = true
+		links = append(links, FeedieLink{URL: href, Type: "text/html"})
+	})
+	thumbnail := ""
+	doc.Find("img[src]").EachWithBreak(func(_ int, img *goquery.Selection) bool {
+		if img.AttrOr("width", "") == "1" || img.AttrOr("height", "") == "1" {
+			return true
+		}
+		src := img.AttrOr("src", "")
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			thumbnail = src
+			return false
+		}
+		return true
+	})
+	return thumbnail, links
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMultipartAlternative(t *testing.T) {
+	msg := strings.ReplaceAll(`From: "Weekly Digest #12" <digest@news.example.com>
+List-Id: Weekly Digest <weekly.news.example.com>
+Subject: This week
+Message-Id: <issue-12@news.example.com>
+Date: Sun, 10 Mar 2024 12:00:00 +0000
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Read it at https://news.example.com/12
+--b1
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<p>Read <a href=3D"https://news.example.com/12">issue 12</a></p>
+<img src=3D"https://news.example.com/pixel.gif" width=3D"1">
+<img src=3D"https://news.example.com/cover.png">
+--b1--
+`, "\n", "\r\n")
+	feed, err := parseMessage(strings.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Url != "list:weekly.news.example.com" || feed.Title != "Weekly Digest" {
+		t.Errorf("got feed %q titled %q", feed.Url, feed.Title)
+	}
+	e := feed.Entries[0]
+	if e.Title != "This week" || e.Author != "Weekly Digest #12" || e.GUID != "issue-12@news.example.com" || e.Published != 1710072000 {
+		t.Errorf("unexpected entry %q by %q, %q at %d", e.Title, e.Author, e.GUID, e.Published)
+	}
+	// the html alternative is preferred
+	if !strings.Contains(e.Description, `<a href="https://news.example.com/12">issue 12</a>`) {
+		t.Errorf("unexpected description %q", e.Description)
+	}
+	if e.Thumbnail != "https://news.example.com/cover.png" {
+		t.Errorf("thumbnail %q, want the cover", e.Thumbnail)
+	}
+	if len(e.Links) != 1 || e.Links[0].URL != "https://news.example.com/12" {
+		t.Errorf("unexpected links %v", e.Links)
+	}
+}
+
+func TestParseEncodedHeaders(t *testing.T) {
+	issue := func(subject string) string {
+		return strings.ReplaceAll(`From: =?ISO-8859-1?Q?Caf=E9_Notes?= <Notes@Cafe.example.com>
+Subject: `+subject+`
+Content-Type: text/plain; charset=iso-8859-1
+Content-Transfer-Encoding: base64
+
+Q2Fm6SBvcGVucyBhdCA4
+`, "\n", "\r\n")
+	}
+	feed, err := parseMessage(strings.NewReader(issue("=?UTF-8?B?TWVudSDinJM=?=")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := feed.Entries[0]
+	if e.Title != "Menu ✓" || e.Author != "Café Notes" {
+		t.Errorf("got %q by %q", e.Title, e.Author)
+	}
+	if e.Description != "<p>Café opens at 8</p>" {
+		t.Errorf("unexpected description %q", e.Description)
+	}
+
+	// mail without a List-Id is titled after the sender's address, whatever
+	// name an issue is sent with
+	if feed.Url != "mailto:notes@cafe.example.com" || feed.Title != "notes@cafe.example.com" {
+		t.Errorf("got feed %q titled %q", feed.Url, feed.Title)
+	}
+	renamed := strings.Replace(issue("Menu"), "Caf=E9_Notes", "Caf=E9_Notes_#2", 1)
+	next, err := parseMessage(strings.NewReader(renamed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Url != feed.Url || next.Title != feed.Title {
+		t.Errorf("next issue went to %q titled %q", next.Url, next.Title)
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,107 +20,104 @@ const DEFAULT_REFRESH = 9000 // ~2.5 hrs
 
 
 type FeedieServer struct{
-	port int 
+	config FeedieServerConfig
 	timeOfNextRefresh int64
-	refreshRate int64
-	dbFilePath string
+	// cancelled on shutdown, see serve
+	ctx context.Context
+	jobs sync.WaitGroup
 }
 
 var feedieServer *FeedieServer
 func feedieInit(){
-	feedieServer = &FeedieServer{}
-	if v, exists := os.LookupEnv("FEEDIE_SERVER_PORT"); exists{
-		port, err:= strconv.Atoi(v)
-		if err != nil{
-			log.Fatal(err)
-		}
-		feedieServer.port = port
-	}else{
-		feedieServer.port = DEFAULT_PORT
-	}
-	if v, exists := os.LookupEnv("FEEDIE_SERVER_REFRESH_RATE"); exists{
-		rr, err:= strconv.Atoi(v)
-		if err != nil{
-			log.Fatal(err)
-		}
-		feedieServer.refreshRate = int64(rr)
-	}else{
-		feedieServer.refreshRate = DEFAULT_REFRESH
-	}
-	if v, exists := os.LookupEnv("FEEDIE_SERVER_DB_PATH"); exists{
-		path := v
-		feedieServer.dbFilePath = path
-	}else{
-		if v, exists := os.LookupEnv("HOME"); exists{
-			path := v
-			feedieServer.dbFilePath = path + "/.local/share/feedie/feedie.db"
-		}
-
-
+	conf, err := loadServerConfig(serverConfigPath())
+	if err != nil{
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
+	feedieServer = &FeedieServer{config: conf}
 }
 
 func main(){
-	feedieInit()
-	DBInit(feedieServer.dbFilePath)
-	args := os.Args
-	if len(args) >1 {
-		if args[1] == "migrate_add_link_id" {
-			migrateAddLinkID()
-			log.Println("Successful Migration")
-			return
-		}
-		if args[1] == "migrate_dedup_guid" {
-			migrateDedupGUID()
-			log.Println("Successful Migration")
-			return
-		}
-	}
-	go refreshThread(feedieServer.refreshRate)
-	FeedieStartServer(feedieServer.port)
+	os.Exit(runCLI(os.Args[1:]))
 }
 
-func refreshThread(timeInSeconds int64){
-	feedieServer.timeOfNextRefresh = time.Now().Unix() + feedieServer.refreshRate
-	for true{
-		log.Println("Refreshing feeds")
+func refreshThread(ctx context.Context, timeInSeconds int64){
+	feedieServer.timeOfNextRefresh = time.Now().Unix() + timeInSeconds
+	workers := make(chan struct{}, feedieServer.config.RefreshWorkers)
+	for ctx.Err() == nil{
+		if n := DBPurgeEntries(feedieServer.config.Retention); n > 0 {
+			slog.Info("deleted entries past retention", "count", n)
+		}
+		slog.Info("refreshing feeds")
 		feeds := DBGetFeeds(false)
 		for _, feed := range feeds{
-			go func () {
-				newFeed := parser(feed.Url)
-				if newFeed == nil{
-					log.Printf("unable to refresh feed: %s", feed.Url)
-					return
-				}
-				DBAddFeedWithEntries(*newFeed)
-				log.Printf("Refreshed feed: %s\n", newFeed.Url)
-			}()
+			// pushed feeds don't need polling while their lease lasts
+			if feed.Dead || isMailFeed(feed.Url) || DBWebSubActive(feed.Url){
+				continue
+			}
+			select{
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			goJob(func () {
+				defer func() { <-workers }()
+				refreshFeed(feed.Url)
+			})
 
 		}
 		for time.Now().Unix() < feedieServer.timeOfNextRefresh{
-			time.Sleep(time.Duration(5) * time.Second)
+			if !sleepCtx(ctx, time.Duration(5) * time.Second){
+				return
+			}
 		}
 		feedieServer.timeOfNextRefresh += timeInSeconds
 	}
 }
 
+// refreshFeed fetches feed and ingests its entries, reporting whether it
+// could be fetched.
+func refreshFeed(feedURL string) bool{
+	newFeed, err := parser(feedURL)
+	if err != nil{
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.Code == http.StatusGone{
+			DBSetFeedDead(feedURL, statusErr.Status)
+			slog.Warn("feed is gone, no longer refreshing it", "feed", feedURL)
+			return false
+		}
+		slog.Warn("unable to refresh feed", "feed", feedURL, "err", err)
+		return false
+	}
+	if newFeed.movedTo != "" && newFeed.movedTo != feedURL{
+		DBMoveFeed(feedURL, newFeed.movedTo)
+		slog.Info("feed moved permanently", "feed", feedURL, "to", newFeed.movedTo)
+		newFeed.Url = newFeed.movedTo
+	}
+	DBAddFeedWithEntries(*newFeed)
+	slog.Info("refreshed feed", "feed", newFeed.Url)
+	websubSubscribe(*newFeed)
+	fetchFullText(newFeed.Url)
+	return true
+}
+
 func migrateDedupGUID(){
 	type entryRow struct {
-		id        string
-		feed_id   string
+		id        int64
+		hash      string
+		feed_id   int64
 		title     string
 		author    string
 		published int64
 	}
 
 	dbMu.RLock()
-	rows, err := db.Query("SELECT id, feed_id, title, author, published FROM entries")
+	rows, err := db.Query("SELECT id, hash, feed_id, title, author, published FROM entries")
 	if err != nil { log.Fatal(err) }
 	var entries []entryRow
 	for rows.Next() {
 		var e entryRow
-		if err := rows.Scan(&e.id, &e.feed_id, &e.title, &e.author, &e.published); err != nil {
+		if err := rows.Scan(&e.id, &e.hash, &e.feed_id, &e.title, &e.author, &e.published); err != nil {
 			log.Fatal(err)
 		}
 		entries = append(entries, e)
@@ -122,14 +126,14 @@ func migrateDedupGUID(){
 	dbMu.RUnlock()
 
 	// Group by (feed_id, title, author, published)
-	type groupKey struct{ feed_id, title, author string; published int64 }
+	type groupKey struct{ feed_id int64; title, author string; published int64 }
 	groups := map[groupKey][]entryRow{}
 	for _, e := range entries {
 		k := groupKey{e.feed_id, e.title, e.author, e.published}
 		groups[k] = append(groups[k], e)
 	}
 
-	// For each duplicate group, delete entries whose ID matches the old
+	// For each duplicate group, delete entries whose hash matches the old
 	// hash scheme (title+author+published). The GUID-based entry remains.
 	deleted := 0
 	for _, group := range groups {
@@ -138,36 +142,125 @@ func migrateDedupGUID(){
 		}
 		for _, e := range group {
 			oldHash := GetHashString(e.title + e.author + fmt.Sprintf("%d", e.published))
-			if e.id == oldHash {
+			if e.hash == oldHash {
 				dbMu.Lock()
 				_, err := db.Exec("DELETE FROM entries WHERE id = ?", e.id)
 				dbMu.Unlock()
 				if err != nil { log.Fatal(err) }
-				log.Printf("Deleted old-hash duplicate: %s (title: %q)", e.id, e.title)
+				slog.Info("deleted old-hash duplicate", "entry", e.id, "title", e.title)
 				deleted++
 			}
 		}
 	}
-	log.Printf("Removed %d duplicate entries", deleted)
+	slog.Info("removed duplicate entries", "count", deleted)
 }
 
-func migrateAddLinkID(){
-	DBExecuteSQL("ALTER TABLE links RENAME TO links_old;", []any{})
-	DBExecuteSQL(`CREATE TABLE IF NOT EXISTS links (
-    id TEXT PRIMARY KEY,
-    url TEXT NOT NULL,
-    entry_id TEXT NOT NULL,
-    link_type TEXT,
-    FOREIGN KEY (entry_id) REFERENCES entries(id) ON DELETE CASCADE
-		);`,[]any{})
-DBExecuteSQL(`INSERT INTO links (id, url, entry_id, link_type)
-		SELECT
-		url || entry_id AS id, 
-		url,
-		entry_id,
-		link_type
-		FROM links_old;
-		`, []any{})
-DBExecuteSQL(`DROP TABLE links_old;`, []any{})
+// migrateSplitGUID splits entries merged by feeds using the same GUIDs, which
+// shared one id before entries were identified within their feed. The feed
+// refreshed last kept the entry and links from both. A link on the host of
+// another feed is moved to that feed's copy of the entry, which is created
+// if needed, with the entry's read and starred state, and filled in on the
+// feed's next refresh. GUIDs that are urls
+// are unique and entries without a link on their own feed's host can't be
+// told apart, so those are left alone.
+func migrateSplitGUID(){
+	type linkRow struct {
+		id      int64
+		entryID int64
+		feedID  int64
+		guid    string
+		url     string
+	}
+
+	dbMu.RLock()
+	feedHosts := map[int64]string{}
+	hostFeeds := map[string][]int64{}
+	rows, err := db.Query("SELECT id, url FROM feeds")
+	if err != nil { log.Fatal(err) }
+	for rows.Next() {
+		var id int64
+		var feedURL string
+		if err := rows.Scan(&id, &feedURL); err != nil { log.Fatal(err) }
+		host := linkHost(feedURL)
+		feedHosts[id] = host
+		hostFeeds[host] = append(hostFeeds[host], id)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT l.id, e.id, e.feed_id, e.guid, l.url
+FROM links AS l JOIN entries AS e ON e.id = l.entry_id
+ORDER BY e.id, l.id`)
+	if err != nil { log.Fatal(err) }
+	byEntry := map[int64][]linkRow{}
+	for rows.Next() {
+		var l linkRow
+		if err := rows.Scan(&l.id, &l.entryID, &l.feedID, &l.guid, &l.url); err != nil {
+			log.Fatal(err)
+		}
+		byEntry[l.entryID] = append(byEntry[l.entryID], l)
+	}
+	rows.Close()
+	dbMu.RUnlock()
+
+	// links to move, by entry and the feed they belong to
+	type split struct{ entryID, feedID int64; guid string }
+	moves := map[split][]int64{}
+	for _, links := range byEntry {
+		guid, ownHost := links[0].guid, feedHosts[links[0].feedID]
+		if u, err := url.Parse(guid); ownHost == "" || err == nil && u.Scheme != "" {
+			continue
+		}
+		if !slices.ContainsFunc(links, func(l linkRow) bool { return linkHost(l.url) == ownHost }) {
+			continue
+		}
+		for _, l := range links {
+			host := linkHost(l.url)
+			if host == "" || host == ownHost || len(hostFeeds[host]) != 1 {
+				continue
+			}
+			k := split{l.entryID, hostFeeds[host][0], guid}
+			moves[k] = append(moves[k], l.id)
+		}
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	tx, err := db.Begin()
+	if err != nil { log.Fatal(err) }
+	for k, linkIDs := range moves {
+		var target int64
+		err := tx.QueryRow(`INSERT INTO entries
+(hash, feed_id, title, author, published, description, thumbnail, guid, read, starred, hidden, first_seen)
+SELECT ?, ?, title, author, published, description, thumbnail, guid, read, starred, hidden, first_seen
+FROM entries WHERE id = ?
+ON CONFLICT(feed_id, guid) DO UPDATE SET guid = excluded.guid
+RETURNING id`, entryHash(k.feedID, k.guid), k.feedID, k.entryID).Scan(&target)
+		if err != nil { tx.Rollback(); log.Fatal(err) }
+		for _, linkID := range linkIDs {
+			// the copy may have the link already
+			_, err = tx.Exec(`UPDATE OR IGNORE links SET entry_id = ? WHERE id = ?`, target, linkID)
+			if err == nil {
+				_, err = tx.Exec(`DELETE FROM links WHERE id = ? AND entry_id != ?`, linkID, target)
+			}
+			if err != nil { tx.Rollback(); log.Fatal(err) }
+		}
+		slog.Info("split merged entry", "entry", k.entryID, "feed", k.feedID, "into", target)
+	}
+	if err := tx.Commit(); err != nil { log.Fatal(err) }
+	slog.Info("split merged entries", "count", len(moves))
+}
 
+// linkHost returns the host of rawURL without a leading www.
+func linkHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// migrateAddLinkID is kept for scripts that still run it: links are given
+// integer ids when the database is opened, along with everything else.
+func migrateAddLinkID(){
+	slog.Info("links already have ids, nothing to migrate")
 }
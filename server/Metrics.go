@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics, served on /metrics behind the same token as the API.
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedie_http_requests_total",
+		Help: "API requests served, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedie_http_request_duration_seconds",
+		Help:    "Time taken to serve API requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	feedFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedie_feed_fetch_duration_seconds",
+		Help:    "Time taken to fetch and parse feeds, by feed url.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"feed"})
+
+	feedFetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedie_feed_fetch_failures_total",
+		Help: "Feed fetches that failed, by feed url.",
+	}, []string{"feed"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "feedie_db_query_duration_seconds",
+		Help:    "Time taken by database operations, including waiting for the database lock.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"query"})
+
+	entryCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "feedie_entry_cache_lookups_total",
+		Help: "Entry list queries looked up in the cache, by result: hit or miss.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(countCollector{})
+}
+
+// observeQuery starts timing the database operation name; call the returned
+// function when it is done.
+func observeQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+var (
+	entriesDesc = prometheus.NewDesc("feedie_entries",
+		"Entries stored, by state: all, unread or starred.", []string{"state"}, nil)
+	feedsDesc = prometheus.NewDesc("feedie_feeds", "Feeds subscribed to.", nil, nil)
+)
+
+// countCollector counts entries and feeds in the database when scraped.
+type countCollector struct{}
+
+func (countCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- entriesDesc
+	ch <- feedsDesc
+}
+
+func (countCollector) Collect(ch chan<- prometheus.Metric) {
+	if db == nil {
+		return
+	}
+	all, unread, starred, feeds := DBCounts()
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(all), "all")
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(unread), "unread")
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(starred), "starred")
+	ch <- prometheus.MustNewConstMetric(feedsDesc, prometheus.GaugeValue, float64(feeds))
+}
+
+func DBCounts() (all, unread, starred, feeds int64) {
+	defer observeQuery("counts")()
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT
+	(SELECT COUNT(*) FROM entries),
+	(SELECT COUNT(*) FROM entries WHERE read = 0),
+	(SELECT COUNT(*) FROM entries WHERE starred = 1),
+	(SELECT COUNT(*) FROM feeds)`).Scan(&all, &unread, &starred, &feeds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return all, unread, starred, feeds
+}
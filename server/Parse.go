@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,13 +12,14 @@ import (
 
 
 
-func parser(url string) *FeedieFeed{
-	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(url)
-	if err != nil {
-		log.Printf("unable to parse :%s, %s",url, err)
-		return nil
-	}
+// parser fetches the feed at url through the source adapter configured for
+// it, RSS/Atom unless set otherwise.
+func parser(url string) (*FeedieFeed, error){
+	return fetchSource(DBGetFeedSource(url))
+}
+
+// convertFeed turns a feed parsed by gofeed into a FeedieFeed.
+func convertFeed(feed *gofeed.Feed, url string) *FeedieFeed{
 	var items []FeedieEntry
 	for _, item := range feed.Items {
 		entry := newEmptyEntry()
@@ -32,24 +34,9 @@ func parser(url string) *FeedieFeed{
 			entry.Author = feed.Title
 		}
 
-		pubtime, err := dateparse.ParseAny(item.Published)
-		if err != nil{
-			if strings.Contains(err.Error(),"hour out of range"){
-				pubStr := item.Published
-				pubStr = strings.Replace(pubStr,"24:","00:", 1)
-				pubtime, err = dateparse.ParseAny(pubStr)
-				if err == nil{
-					pubtime = pubtime.Add(24 * time.Hour)
-				} else{
-					pubtime = time.Now()
-					log.Printf("(%s) unable to parse date %s, %v\n", url, item.Published , err)
-				}
-			} else{
-				pubtime = time.Now()
-				log.Printf("(%s) unable to parse date %s, %v\n", url, item.Published , err)
-			}
-		}
-		entry.Published = int64(pubtime.Unix())
+		var dated bool
+		entry.Published, dated = parsePublished(item.Published, url)
+		entry.undated = !dated
 
 		entry.Description = item.Description
 		if len(entry.Description) < len(item.Content){
@@ -68,6 +55,10 @@ func parser(url string) *FeedieFeed{
 		for _, enc := range item.Enclosures{
 			fl := FeedieLink{URL: enc.URL, Type: enc.Type}
 			entry.Links = append(entry.Links, fl)
+			if entry.Podcast == nil && isMediaType(enc.Type){
+				entry.Podcast = &FeediePodcast{}
+				entry.Podcast.Size, _ = strconv.ParseInt(enc.Length, 10, 64)
+			}
 		}
 
 
@@ -82,9 +73,36 @@ func parser(url string) *FeedieFeed{
 
 	}
 	parsedFeed := newFeed(feed.Title, url, items)
+	// the site may be elsewhere than its feed
+	if favicon := faviconURL(feed.Link); favicon != ""{
+		parsedFeed.FaviconURL = favicon
+	}
 	return parsedFeed
 }
 
+// parsePublished parses a feed's date string, falling back to the current
+// time if it can't be read, and reports whether it could.
+func parsePublished(published string, url string) (int64, bool){
+	pubtime, err := dateparse.ParseAny(published)
+	if err != nil{
+		if strings.Contains(err.Error(),"hour out of range"){
+			pubStr := published
+			pubStr = strings.Replace(pubStr,"24:","00:", 1)
+			pubtime, err = dateparse.ParseAny(pubStr)
+			if err == nil{
+				pubtime = pubtime.Add(24 * time.Hour)
+			} else{
+				slog.Debug("unable to parse date", "feed", url, "date", published, "err", err)
+				return time.Now().Unix(), false
+			}
+		} else{
+			slog.Debug("unable to parse date", "feed", url, "date", published, "err", err)
+			return time.Now().Unix(), false
+		}
+	}
+	return int64(pubtime.Unix()), true
+}
+
 func parseItunes(feedItem *gofeed.Item, feedFeed *gofeed.Feed, outItem *FeedieEntry){
 	if feedItem.ITunesExt != nil{
 		itunes := feedItem.ITunesExt
@@ -97,9 +115,37 @@ func parseItunes(feedItem *gofeed.Item, feedFeed *gofeed.Feed, outItem *FeedieEn
 		if(feedFeed.Image != nil && len(outItem.Thumbnail) < len (feedFeed.Image.URL)){
 			outItem.Thumbnail = feedFeed.Image.URL
 		}
+		if outItem.Podcast == nil{
+			outItem.Podcast = &FeediePodcast{}
+		}
+		outItem.Podcast.Duration = parseDuration(itunes.Duration)
+		outItem.Podcast.Episode, _ = strconv.Atoi(strings.TrimSpace(itunes.Episode))
+		outItem.Podcast.Season, _ = strconv.Atoi(strings.TrimSpace(itunes.Season))
 	}
 }
 
+func isMediaType(mimeType string) bool{
+	return strings.HasPrefix(mimeType, "audio/") || strings.HasPrefix(mimeType, "video/")
+}
+
+// parseDuration reads durations given as seconds, MM:SS or HH:MM:SS, with
+// optional fractional seconds, as used by itunes:duration and chapter starts.
+func parseDuration(s string) int64{
+	s = strings.TrimSpace(s)
+	if s == ""{
+		return 0
+	}
+	var total float64
+	for _, part := range strings.Split(s, ":"){
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil{
+			return 0
+		}
+		total = total*60 + v
+	}
+	return int64(total)
+}
+
 func parseExtensions(feedItem *gofeed.Item, feedFeed *gofeed.Feed, outItem *FeedieEntry){
 	//media
 	if media, ok := feedItem.Extensions["media"]; ok {
@@ -126,7 +172,26 @@ func parseExtensions(feedItem *gofeed.Item, feedFeed *gofeed.Feed, outItem *Feed
 		if thumbs, ok := media["thumbnail"]; ok && len(thumbs) > 0 {
 			outItem.Thumbnail = thumbs[0].Attrs["url"]
 	  }
+		parseMediaStats(media, outItem)
   }
 
+	//podcasting 2.0 chapters file
+	if podcast, ok := feedItem.Extensions["podcast"]; ok && outItem.Podcast != nil {
+		if chapters, ok := podcast["chapters"]; ok && len(chapters) > 0 {
+			outItem.Podcast.ChaptersURL = chapters[0].Attrs["url"]
+		}
+	}
+	//podlove simple chapters
+	if psc, ok := feedItem.Extensions["psc"]; ok && outItem.Podcast != nil {
+		if chapters, ok := psc["chapters"]; ok && len(chapters) > 0 {
+			for _, c := range chapters[0].Children["chapter"] {
+				outItem.Podcast.Chapters = append(outItem.Podcast.Chapters, FeedieChapter{
+					Start: parseDuration(c.Attrs["start"]),
+					Title: c.Attrs["title"],
+				})
+			}
+		}
+	}
+
 }
 
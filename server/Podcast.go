@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Episode metadata of podcast entries lives in the podcasts table, keyed by
+// entry, together with the playback position reported by clients.
+
+// upsertPodcast stores the episode metadata of an ingested entry, keeping
+// the playback position already recorded for it, and the chapters fetched
+// from its chapters url while that doesn't change.
+func upsertPodcast(tx *sql.Tx, entryID int64, p *FeediePodcast) {
+	chapters, err := json.Marshal(p.Chapters)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	_, err = tx.Exec(`INSERT INTO podcasts
+(entry_id, duration, size, episode, season, chapters_url, chapters)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(entry_id) DO UPDATE SET
+    duration = excluded.duration,
+    size = excluded.size,
+    episode = excluded.episode,
+    season = excluded.season,
+    chapters = CASE WHEN excluded.chapters = 'null' AND chapters_url = excluded.chapters_url
+        THEN podcasts.chapters ELSE excluded.chapters END,
+    chapters_url = excluded.chapters_url;`,
+		entryID, p.Duration, p.Size, p.Episode, p.Season, p.ChaptersURL, string(chapters))
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+}
+
+func attachPodcasts(byID map[int64]*FeedieEntry, ids []any) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := db.Query(fmt.Sprintf(`SELECT entry_id, duration, size, episode, season,
+       chapters_url, chapters, position
+FROM podcasts WHERE entry_id IN (%s)`, placeholders), ids...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var chapters string
+		p := FeediePodcast{}
+		err := rows.Scan(&id, &p.Duration, &p.Size, &p.Episode, &p.Season,
+			&p.ChaptersURL, &chapters, &p.Position)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(chapters), &p.Chapters); err != nil {
+			slog.Warn("invalid chapters", "entry", id, "err", err)
+		}
+		if e, ok := byID[id]; ok {
+			e.Podcast = &p
+		}
+	}
+}
+
+// podcastChaptersFile is the Podcasting 2.0 JSON chapters format, see
+// https://github.com/Podcastindex-org/podcast-namespace/blob/main/docs/examples/chapters/jsonChapters.md
+type podcastChaptersFile struct {
+	Chapters []struct {
+		StartTime float64 `json:"startTime"`
+		Title     string  `json:"title"`
+		// chapters outside the table of contents are only shown by players
+		TOC *bool `json:"toc"`
+	} `json:"chapters"`
+}
+
+// fetchChapters reads the chapters file feeds link with podcast:chapters.
+func fetchChapters(chaptersURL string) ([]FeedieChapter, error) {
+	resp, err := fetchPage(chaptersURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var file podcastChaptersFile
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid chapters file: %w", err)
+	}
+	chapters := []FeedieChapter{}
+	for _, c := range file.Chapters {
+		if c.TOC != nil && !*c.TOC {
+			continue
+		}
+		chapters = append(chapters, FeedieChapter{Start: int64(c.StartTime), Title: c.Title})
+	}
+	return chapters, nil
+}
+
+// DBSetChapters stores the chapters fetched for an entry.
+func DBSetChapters(entryID int64, chapters []FeedieChapter) {
+	encoded, err := json.Marshal(chapters)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err = db.Exec(`UPDATE podcasts SET chapters = ? WHERE entry_id = ?`, string(encoded), entryID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	invalidateEntryCache()
+}
+
+func DBSetPosition(entryID int64, position int64) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO podcasts (entry_id, position)
+VALUES (?, ?)
+ON CONFLICT(entry_id) DO UPDATE SET
+    position = excluded.position;`, entryID, position)
+	if err != nil {
+		log.Fatal(err)
+	}
+	invalidateEntryCache()
+}
+
+func setPositionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link := r.URL.Query().Get("url")
+	position, err := strconv.ParseInt(r.URL.Query().Get("position"), 10, 64)
+	if link == "" || err != nil || position < 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid url or position value")
+		return
+	}
+	entryID := DBGetEntryIDByLink(link)
+	if entryID == 0 {
+		slog.WarnContext(r.Context(), "no entry with url", "url", link)
+		http.Error(w, "unknown enclosure url", http.StatusNotFound)
+		return
+	}
+
+	DBSetPosition(entryID, position)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChaptersFileIsFetchedOnce(t *testing.T) {
+	initTestDB(t)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		fmt.Fprint(w, `{"version": "1.2.0", "chapters": [
+			{"startTime": 0, "title": "Intro"},
+			{"startTime": 61.5, "title": "Ad", "toc": false},
+			{"startTime": 125.2, "title": "Interview"}]}`)
+	}))
+	defer srv.Close()
+
+	feed := testFeed("http://a.example.com/feed", "episode")
+	feed.Entries[0].Podcast = &FeediePodcast{Duration: 3600, ChaptersURL: srv.URL + "/chapters.json"}
+	DBAddFeedWithEntries(feed)
+	id := DBGetEntryIDByLink("http://a.example.com/feed/episode")
+
+	getChapters := func() []FeedieChapter {
+		rec := httptest.NewRecorder()
+		getEntryHandler(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/get_entry?id=%d", id), nil))
+		var entry FeedieEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entry); err != nil || entry.Podcast == nil {
+			t.Fatalf("answered %d: %v", rec.Code, err)
+		}
+		return entry.Podcast.Chapters
+	}
+	want := []FeedieChapter{{0, "Intro"}, {125, "Interview"}}
+	for range 2 {
+		if got := getChapters(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("chapters %v, want %v", got, want)
+		}
+	}
+	// refreshing the feed keeps the chapters read from its file
+	DBAddFeedWithEntries(feed)
+	if got := getChapters(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("chapters after refreshing %v, want %v", got, want)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("chapters file fetched %d times", n)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Many feeds only carry a teaser in their description. The extractor below
+// fetches an entry's web page and pulls out the main article body, which is
+// cached in the articles table.
+
+// number of articles fetched per feed and refresh for feeds with full text
+// enabled, to avoid hammering a site the first time it is turned on
+const articlesPerRefresh = 20
+
+// minimum amount of text for an <article>/<main> element to be trusted as
+// the article body without scoring paragraphs
+const minArticleText = 200
+
+var errNoArticle = errors.New("no article content found")
+
+func extractArticle(pageURL string) (string, error) {
+	resp, err := fetchPage(pageURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return "", fmt.Errorf("unexpected content type %s", ct)
+	}
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return extractArticleFromDoc(doc, resp.Request.URL)
+}
+
+func extractArticleFromDoc(doc *goquery.Document, base *url.URL) (string, error) {
+	doc.Find("script, style, noscript, nav, header, footer, aside, form, iframe, svg, button").Remove()
+
+	var best *goquery.Selection
+	for _, sel := range []string{"[itemprop=articleBody]", "article", "main", "[role=main]"} {
+		s := doc.Find(sel).First()
+		if s.Length() > 0 && len(strings.TrimSpace(s.Text())) >= minArticleText {
+			best = s
+			break
+		}
+	}
+
+	// no usable semantic element: score the parents of paragraphs by how
+	// much text they hold and pick the highest
+	if best == nil {
+		scores := map[*html.Node]int{}
+		doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+			text := strings.TrimSpace(p.Text())
+			if len(text) < 25 {
+				return
+			}
+			score := 1 + len(text)/100 + strings.Count(text, ",")
+			parent := p.Parent()
+			if parent.Length() == 0 {
+				return
+			}
+			scores[parent.Get(0)] += score
+			if grand := parent.Parent(); grand.Length() > 0 {
+				scores[grand.Get(0)] += score / 2
+			}
+		})
+		var bestNode *html.Node
+		for node, score := range scores {
+			if bestNode == nil || score > scores[bestNode] {
+				bestNode = node
+			}
+		}
+		if bestNode == nil {
+			return "", errNoArticle
+		}
+		best = doc.FindNodes(bestNode)
+	}
+
+	absolutize(best, base, "a", "href")
+	absolutize(best, base, "img", "src")
+	content, err := goquery.OuterHtml(best)
+	if err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// absolutize rewrites relative attr urls of tag elements against base, since
+// the article is displayed away from the page it came from.
+func absolutize(s *goquery.Selection, base *url.URL, tag, attr string) {
+	s.Find(tag).Each(func(_ int, el *goquery.Selection) {
+		v, ok := el.Attr(attr)
+		if !ok {
+			return
+		}
+		ref, err := url.Parse(v)
+		if err != nil {
+			return
+		}
+		el.SetAttr(attr, base.ResolveReference(ref).String())
+	})
+}
+
+// getArticle returns the cached article for the entry owning link, extracting
+// and caching it first if needed.
+func getArticle(link string) (string, error) {
+	entryID := DBGetEntryIDByLink(link)
+	if entryID == 0 {
+		return "", fmt.Errorf("no entry with link %s", link)
+	}
+	if content, ok := DBGetArticle(entryID); ok {
+		return content, nil
+	}
+	content, err := extractArticle(link)
+	if err != nil {
+		return "", err
+	}
+	DBSetArticle(entryID, content)
+	return content, nil
+}
+
+// fetchFullText extracts articles for the newest entries of feed that don't
+// have one yet, if full text is enabled for the feed.
+func fetchFullText(feedURL string) {
+	if !DBGetFeedFullText(feedURL) {
+		return
+	}
+	for _, job := range DBGetEntriesWithoutArticle(feedURL, articlesPerRefresh) {
+		if serverContext().Err() != nil {
+			return
+		}
+		content, err := extractArticle(job.link)
+		if err != nil {
+			slog.Warn("unable to extract article", "url", job.link, "err", err)
+			continue
+		}
+		DBSetArticle(job.entryID, content)
+	}
+}
+
+type articleJob struct {
+	entryID int64
+	link    string
+}
+
+// DBGetEntryIDByLink returns the id of the entry owning link, or 0 if there
+// is none.
+func DBGetEntryIDByLink(link string) int64 {
+	var entryID int64
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT entry_id FROM links WHERE url = ? LIMIT 1`, link).Scan(&entryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0
+		}
+		log.Fatal(err)
+	}
+	return entryID
+}
+
+func DBGetArticle(entryID int64) (string, bool) {
+	var content string
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT content FROM articles WHERE entry_id = ?`, entryID).Scan(&content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false
+		}
+		log.Fatal(err)
+	}
+	return content, true
+}
+
+func DBSetArticle(entryID int64, content string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO articles (entry_id, content, fetched)
+VALUES (?, ?, ?)
+ON CONFLICT(entry_id) DO UPDATE SET
+    content = excluded.content,
+    fetched = excluded.fetched;`, entryID, content, time.Now().Unix())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DBGetFeedFullText(feedURL string) bool {
+	var enabled bool
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT full_text FROM feeds WHERE url = ?`, feedURL).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false
+		}
+		log.Fatal(err)
+	}
+	return enabled
+}
+
+func DBSetFeedFullText(feedURL string, enabled bool) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`UPDATE feeds SET full_text = ? WHERE url = ?`, enabled, feedURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DBGetEntriesWithoutArticle(feedURL string, limit int) []articleJob {
+	ret := []articleJob{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT e.id, (
+	SELECT l.url FROM links AS l
+	WHERE l.entry_id = e.id AND l.link_type = 'text/html'
+	ORDER BY l.rowid LIMIT 1) AS link
+FROM entries AS e
+JOIN feeds AS f ON f.id = e.feed_id
+WHERE f.url = ?
+AND link IS NOT NULL
+AND NOT EXISTS (SELECT 1 FROM articles AS a WHERE a.entry_id = e.id)
+ORDER BY e.published DESC
+LIMIT ?`, feedURL, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var job articleJob
+		if err := rows.Scan(&job.entryID, &job.link); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, job)
+	}
+	return ret
+}
+
+func getArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link := r.URL.Query().Get("url")
+	if link == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "url value empty")
+		return
+	}
+
+	content, err := getArticle(link)
+	if err != nil {
+		slog.WarnContext(r.Context(), "unable to extract article", "url", link, "err", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	data := map[string]string{"URL": link, "Content": content}
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
+	}
+}
+
+func setFeedFullTextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	feedURL := r.URL.Query().Get("feed_url")
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if feedURL == "" || err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid feed_url or enabled value")
+		return
+	}
+
+	DBSetFeedFullText(feedURL, enabled)
+	if enabled {
+		goJob(func() { fetchFullText(feedURL) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
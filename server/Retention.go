@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"time"
+)
+
+// Retention deletes old entries after every refresh cycle. The GUIDs of
+// deleted entries are kept in purged_entries, with their feed, so feeds
+// still listing them don't bring them back, and fire rules and webhooks
+// again, on the next refresh. They are forgotten once their feed stops
+// listing them. Entries without a publication date are never too old.
+
+// DBPurgeEntries deletes the entries the retention settings don't keep and
+// returns how many were deleted.
+func DBPurgeEntries(conf RetentionConfig) int64 {
+	defer observeQuery("purge_entries")()
+	if conf.MaxAgeDays == 0 && conf.MaxEntriesPerFeed == 0 {
+		return 0
+	}
+	keepStarred := 0
+	if conf.KeepStarred {
+		keepStarred = 1
+	}
+
+	cutoff := int64(0)
+	if conf.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -conf.MaxAgeDays).Unix()
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	tx, err := db.Begin()
+	if err != nil { log.Fatal(err) }
+
+	rows, err := tx.Query(`SELECT id, feed_id, guid FROM (
+	SELECT id, feed_id, guid, published, starred,
+	ROW_NUMBER() OVER (PARTITION BY feed_id ORDER BY published DESC) AS n
+	FROM entries)
+WHERE ((? > 0 AND published > 0 AND published < ?) OR (? > 0 AND n > ?))
+AND (starred = 0 OR ? = 0)`, cutoff, cutoff, conf.MaxEntriesPerFeed, conf.MaxEntriesPerFeed, keepStarred)
+	if err != nil { tx.Rollback(); log.Fatal(err) }
+	type purged struct {
+		id, feedID int64
+		guid string
+	}
+	entries := []purged{}
+	for rows.Next() {
+		var p purged
+		if err := rows.Scan(&p.id, &p.feedID, &p.guid); err != nil { tx.Rollback(); log.Fatal(err) }
+		entries = append(entries, p)
+	}
+	rows.Close()
+
+	for _, p := range entries {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO purged_entries (feed_id, guid) VALUES (?, ?)`, p.feedID, p.guid)
+		if err != nil { tx.Rollback(); log.Fatal(err) }
+		_, err = tx.Exec(`DELETE FROM entries WHERE id = ?`, p.id)
+		if err != nil { tx.Rollback(); log.Fatal(err) }
+	}
+	if err = tx.Commit(); err != nil { log.Fatal(err) }
+	invalidateEntryCache()
+	return int64(len(entries))
+}
+
+// prunePurgedEntries forgets the purged entries feed no longer lists, as
+// it is ingested. A feed fetched without entries is left alone, in case
+// it was only briefly empty.
+func prunePurgedEntries(tx *sql.Tx, feed FeedieFeed) {
+	if len(feed.Entries) == 0 {
+		return
+	}
+	keys := []string{}
+	for _, entry := range feed.Entries {
+		keys = append(keys, entry.getHashString())
+	}
+	listed, _ := json.Marshal(keys)
+	_, err := tx.Exec(`DELETE FROM purged_entries
+WHERE feed_id = ? AND guid NOT IN (SELECT value FROM json_each(?))`, feed.ID, string(listed))
+	if err != nil { tx.Rollback(); log.Fatal(err) }
+}
+
+// migratePurgedEntries drops purged_entries from when it held hashes of
+// entries. They can't be turned back into feeds and GUIDs, so entries
+// deleted before then are added again if their feeds still list them,
+// until the next retention run deletes them again.
+func migratePurgedEntries() {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM purged_entries`).Scan(&count); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := db.Exec(`DROP TABLE purged_entries`); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("dropped the hashes of purged entries", "count", count)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func feedGUIDs(feed FeedieFeed) []string {
+	guids := []string{}
+	for _, e := range DBGetByFeedTimeOrdered(feed, ASC, allEntries) {
+		guids = append(guids, e.GUID)
+	}
+	return guids
+}
+
+func purgedGUIDs(t *testing.T, feed FeedieFeed) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT p.guid FROM purged_entries AS p
+JOIN feeds AS f ON f.id = p.feed_id WHERE f.url = ? ORDER BY p.guid`, feed.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	guids := []string{}
+	for rows.Next() {
+		var guid string
+		rows.Scan(&guid)
+		guids = append(guids, guid)
+	}
+	return guids
+}
+
+func TestPurgedEntriesStayPurged(t *testing.T) {
+	initTestDB(t)
+	old := time.Now().AddDate(0, 0, -30).Unix()
+	a := numberedFeed("a.example.com", old, 3)
+	// b uses the same GUIDs with recent entries
+	b := numberedFeed("b.example.com", time.Now().Unix(), 3)
+	DBAddFeedWithEntries(a)
+	DBAddFeedWithEntries(b)
+
+	if n := DBPurgeEntries(RetentionConfig{MaxAgeDays: 7}); n != 3 {
+		t.Fatalf("purged %d entries, want 3", n)
+	}
+	DBAddFeedWithEntries(a)
+	DBAddFeedWithEntries(b)
+	if got := feedGUIDs(a); len(got) != 0 {
+		t.Errorf("purged entries came back: %v", got)
+	}
+	// purging a's entries doesn't keep b's out
+	if got := feedGUIDs(b); !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Errorf("entries of b are %v", got)
+	}
+
+	// once a feed stops listing a purged entry, it is forgotten
+	a.Entries = a.Entries[1:]
+	DBAddFeedWithEntries(a)
+	if got := purgedGUIDs(t, a); !slices.Equal(got, []string{"2", "3"}) {
+		t.Errorf("purged entries of a are %v", got)
+	}
+	// and all of them when the feed is deleted
+	DBDelFeed(a.Url)
+	var left int
+	db.QueryRow(`SELECT COUNT(*) FROM purged_entries`).Scan(&left)
+	if left != 0 {
+		t.Errorf("%d purged entries left after deleting the feed", left)
+	}
+}
+
+func TestRetentionKeepsUndatedEntries(t *testing.T) {
+	initTestDB(t)
+	feed := numberedFeed("a.example.com", time.Now().AddDate(0, 0, -30).Unix(), 2)
+	feed.Entries[0].Published = -1
+	DBAddFeedWithEntries(feed)
+
+	if n := DBPurgeEntries(RetentionConfig{MaxAgeDays: 7}); n != 1 {
+		t.Errorf("purged %d entries, want 1", n)
+	}
+	if got := feedGUIDs(feed); !slices.Equal(got, []string{"1"}) {
+		t.Errorf("entries left are %v, want the undated one", got)
+	}
+}
+
+func TestMigratePurgedEntryHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedie.db")
+	DBInit(path)
+	db.Close()
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`DROP TABLE purged_entries`,
+		`CREATE TABLE purged_entries (id TEXT PRIMARY KEY)`,
+		`INSERT INTO purged_entries VALUES ('a1b2c3')`,
+	} {
+		if _, err := old.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	DBInit(path)
+	t.Cleanup(func() { db.Close() })
+	if hasColumn("purged_entries", "id") || !hasColumn("purged_entries", "guid") {
+		t.Error("purged_entries still holds hashes")
+	}
+	feed := numberedFeed("a.example.com", 1000, 1)
+	DBAddFeedWithEntries(feed)
+	DBPurgeEntries(RetentionConfig{MaxEntriesPerFeed: 1})
+	if problems := DBCheck(); len(problems) != 0 {
+		t.Errorf("database check: %v", problems)
+	}
+}
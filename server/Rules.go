@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Filter rules are evaluated against entries as they are ingested. A rule
+// matches one field of an entry against a regex or a list of keywords, all of
+// which have to appear, and applies its action to the entry when it matches.
+
+const (
+	ruleScopeGlobal = "global"
+	ruleScopeTag    = "tag"
+	ruleScopeFeed   = "feed"
+)
+
+const (
+	ruleActionHide     = "hide"
+	ruleActionMarkRead = "mark_read"
+	ruleActionStar     = "star"
+	ruleActionTag      = "tag"
+)
+
+type FeedieRule struct {
+	ID          string
+	Field       string // title, author, description, link or any
+	MatchType   string // regex or keyword
+	Pattern     string
+	Scope       string // global, tag or feed
+	ScopeValue  string // tag name or feed url
+	Action      string // hide, mark_read, star or tag
+	ActionValue string // tag name for the tag action
+	re          *regexp.Regexp
+	keywords    []string
+}
+
+func (r FeedieRule) getHashString() string {
+	return strings.Join([]string{r.Field, r.MatchType, r.Pattern,
+		r.Scope, r.ScopeValue, r.Action, r.ActionValue}, "\x00")
+}
+
+// validate checks the rule's fields and prepares its matcher.
+func (r *FeedieRule) validate() error {
+	switch r.Field {
+	case "title", "author", "description", "link", "any":
+	default:
+		return fmt.Errorf("invalid rule field: %q", r.Field)
+	}
+	switch r.Scope {
+	case ruleScopeGlobal:
+		r.ScopeValue = ""
+	case ruleScopeTag, ruleScopeFeed:
+		if r.ScopeValue == "" {
+			return fmt.Errorf("rule scope %s requires a value", r.Scope)
+		}
+	default:
+		return fmt.Errorf("invalid rule scope: %q", r.Scope)
+	}
+	switch r.Action {
+	case ruleActionHide, ruleActionMarkRead, ruleActionStar:
+		r.ActionValue = ""
+	case ruleActionTag:
+		if r.ActionValue == "" {
+			return errors.New("tag action requires a tag name")
+		}
+	default:
+		return fmt.Errorf("invalid rule action: %q", r.Action)
+	}
+	if r.Pattern == "" {
+		return errors.New("rule pattern is empty")
+	}
+	switch r.MatchType {
+	case "regex":
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return err
+		}
+		r.re = re
+	case "keyword":
+		r.keywords = strings.Fields(strings.ToLower(r.Pattern))
+	default:
+		return fmt.Errorf("invalid rule match type: %q", r.MatchType)
+	}
+	return nil
+}
+
+func (r FeedieRule) matchString(s string) bool {
+	if r.re != nil {
+		return r.re.MatchString(s)
+	}
+	// every keyword has to appear
+	s = strings.ToLower(s)
+	for _, k := range r.keywords {
+		if !strings.Contains(s, k) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r FeedieRule) matches(entry FeedieEntry) bool {
+	var fields []string
+	switch r.Field {
+	case "title":
+		fields = []string{entry.Title}
+	case "author":
+		fields = []string{entry.Author}
+	case "description":
+		fields = []string{entry.Description}
+	case "link":
+		for _, l := range entry.Links {
+			fields = append(fields, l.URL)
+		}
+	case "any":
+		// keywords can be found in different fields, but a regex has to
+		// match within one
+		fields = []string{entry.Title, entry.Author, entry.Description}
+		for _, l := range entry.Links {
+			fields = append(fields, l.URL)
+		}
+		if r.re == nil {
+			fields = []string{strings.Join(fields, "\n")}
+		}
+	}
+	for _, f := range fields {
+		if r.matchString(f) {
+			return true
+		}
+	}
+	return false
+}
+
+func scanRules(rows *sql.Rows) []FeedieRule {
+	ret := []FeedieRule{}
+	for rows.Next() {
+		var r FeedieRule
+		err := rows.Scan(&r.ID, &r.Field, &r.MatchType, &r.Pattern,
+			&r.Scope, &r.ScopeValue, &r.Action, &r.ActionValue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// loadRulesForFeed returns the compiled rules that apply to feed: global
+// rules, rules scoped to the feed itself and rules scoped to one of its tags.
+func loadRulesForFeed(tx *sql.Tx, feed FeedieFeed) []FeedieRule {
+	rows, err := tx.Query(`SELECT id, field, match_type, pattern, scope, scope_value, action, action_value
+FROM rules
+WHERE scope = 'global'
+OR (scope = 'feed' AND scope_value = ?)
+OR (scope = 'tag' AND scope_value IN (
+	SELECT t.name FROM tags AS t
+	JOIN tag_members AS tm ON tm.tag_id = t.id
+	WHERE tm.feed_id = ?));`, feed.Url, feed.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	ret := []FeedieRule{}
+	for _, r := range scanRules(rows) {
+		if err := r.validate(); err != nil {
+			slog.Warn("skipping invalid rule", "rule", r.ID, "err", err)
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// applyRules runs every matching rule's action against a newly inserted entry
+// and returns the rules that matched.
+func applyRules(tx *sql.Tx, rules []FeedieRule, entryID int64, entry FeedieEntry) []FeedieRule {
+	matched := []FeedieRule{}
+	for _, r := range rules {
+		if !r.matches(entry) {
+			continue
+		}
+		matched = append(matched, r)
+		var err error
+		switch r.Action {
+		case ruleActionHide:
+			_, err = tx.Exec(`UPDATE entries SET hidden = 1 WHERE id = ?`, entryID)
+		case ruleActionMarkRead:
+			_, err = tx.Exec(`UPDATE entries SET read = 1 WHERE id = ?`, entryID)
+		case ruleActionStar:
+			_, err = tx.Exec(`UPDATE entries SET starred = 1 WHERE id = ?`, entryID)
+		case ruleActionTag:
+			_, err = tx.Exec(`INSERT INTO tags (hash, name) VALUES (?, ?)
+ON CONFLICT(name) DO NOTHING;`, GetHashString(r.ActionValue), r.ActionValue)
+			if err == nil {
+				_, err = tx.Exec(`INSERT INTO entry_tags (tag_id, entry_id)
+SELECT id, ? FROM tags WHERE name = ?
+ON CONFLICT DO NOTHING;`, entryID, r.ActionValue)
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	return matched
+}
+
+func DBGetRules() []FeedieRule {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT id, field, match_type, pattern, scope, scope_value, action, action_value
+FROM rules ORDER BY scope, scope_value`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	return scanRules(rows)
+}
+
+// DBAddRule inserts rule, or updates the rule with the same id if rule.ID is
+// set. It returns the id the rule was stored under.
+func DBAddRule(rule FeedieRule) (string, error) {
+	if err := rule.validate(); err != nil {
+		return "", err
+	}
+	if rule.ID == "" {
+		rule.ID = GetHashString(rule.getHashString())
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO rules
+	(id, field, match_type, pattern, scope, scope_value, action, action_value)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+    field = excluded.field,
+    match_type = excluded.match_type,
+    pattern = excluded.pattern,
+    scope = excluded.scope,
+    scope_value = excluded.scope_value,
+    action = excluded.action,
+    action_value = excluded.action_value;`,
+		rule.ID, rule.Field, rule.MatchType, rule.Pattern,
+		rule.Scope, rule.ScopeValue, rule.Action, rule.ActionValue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rule.ID, nil
+}
+
+func DBRuleExists(id string) bool {
+	var count int
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT COUNT(*) FROM rules WHERE id = ?`, id).Scan(&count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+func DBDelRule(id string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`DELETE FROM rules WHERE id = ?`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
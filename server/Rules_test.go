@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestRuleMatches(t *testing.T) {
+	entry := *newEntry("Weekly links: Go and SQLite", "Ada Lovelace", 1000, "Notes on databases", "")
+	entry.Links = append(entry.Links, FeedieLink{URL: "http://a.example.com/sponsored/1", Type: "text/html"})
+
+	for _, tc := range []struct {
+		field, matchType, pattern string
+		want                      bool
+	}{
+		{"title", "keyword", "weekly links", true},
+		{"title", "keyword", "LINKS weekly", true},
+		{"title", "keyword", "weekly roundup", false},
+		{"title", "keyword", "sqlite", true},
+		{"author", "keyword", "ada", true},
+		{"author", "keyword", "ada babbage", false},
+		{"description", "keyword", "databases notes", true},
+		{"link", "keyword", "sponsored", true},
+		// keywords of an any rule can be found in different fields
+		{"any", "keyword", "lovelace sponsored", true},
+		{"any", "keyword", "lovelace babbage", false},
+		{"title", "regex", "^Weekly", true},
+		{"title", "regex", "^weekly", false},
+		{"any", "regex", "Lovelace.*Notes", false},
+		{"link", "regex", `/sponsored/\d+$`, true},
+	} {
+		r := FeedieRule{Field: tc.field, MatchType: tc.matchType, Pattern: tc.pattern,
+			Scope: ruleScopeGlobal, Action: ruleActionHide}
+		if err := r.validate(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.matches(entry); got != tc.want {
+			t.Errorf("%s %s %q matched %v, want %v", tc.field, tc.matchType, tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestRulesApplyOnIngest(t *testing.T) {
+	initTestDB(t)
+	for _, r := range []FeedieRule{
+		{Field: "title", MatchType: "keyword", Pattern: "weekly links", Scope: ruleScopeGlobal, Action: ruleActionTag, ActionValue: "roundups"},
+		{Field: "title", MatchType: "regex", Pattern: "^Sponsored:", Scope: ruleScopeGlobal, Action: ruleActionHide},
+	} {
+		if _, err := DBAddRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	feed := testFeed("http://a.example.com/feed", "Weekly links", "Weekly notes", "Sponsored: ads", "links")
+	DBAddFeedWithEntries(feed)
+
+	tagged := []string{}
+	for _, e := range DBGetByTagTimeOrdered("roundups", ASC, allEntries) {
+		tagged = append(tagged, e.Title)
+	}
+	if len(tagged) != 1 || tagged[0] != "Weekly links" {
+		t.Errorf("tagged %v, want [Weekly links]", tagged)
+	}
+	for _, e := range DBGetByFeedTimeOrdered(feed, ASC, allEntries) {
+		if e.Title == "Sponsored: ads" {
+			t.Errorf("hidden entry %q listed", e.Title)
+		}
+	}
+}
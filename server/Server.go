@@ -2,27 +2,59 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func FeedieStartServer(port int){
-	http.HandleFunc("/get_entries", getEntriesHandler)
-	http.HandleFunc("/get_feeds", getFeedsHandler)
-	http.HandleFunc("/get_tags", getTagsHandler)
-	http.HandleFunc("/add_feed", addFeedHandler)
-	http.HandleFunc("/del_feed", delFeedHandler)
-	http.HandleFunc("/add_tag", addTagHandler)
-	http.HandleFunc("/del_tag", delTagHandler)
-	http.HandleFunc("/clear_members", clearTagHandler)
-	http.HandleFunc("/add_member", AddTagMemberHandler)
-	http.HandleFunc("/del_member", DelTagMemberHandler)
-	fmt.Printf("listening on :%d", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d",port), nil)
-	if err != nil{
-		log.Fatal(err)
+func newServeMux() *http.ServeMux{
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get_entries", getEntriesHandler)
+	mux.HandleFunc("/get_feeds", getFeedsHandler)
+	mux.HandleFunc("/get_entry", getEntryHandler)
+	mux.HandleFunc("/get_tags", getTagsHandler)
+	mux.HandleFunc("/add_feed", addFeedHandler)
+	mux.HandleFunc("/del_feed", delFeedHandler)
+	mux.HandleFunc("/add_tag", addTagHandler)
+	mux.HandleFunc("/del_tag", delTagHandler)
+	mux.HandleFunc("/clear_members", clearTagHandler)
+	mux.HandleFunc("/add_member", AddTagMemberHandler)
+	mux.HandleFunc("/del_member", DelTagMemberHandler)
+	mux.HandleFunc("/json_feed", jsonFeedHandler)
+	mux.HandleFunc("/feeds/tag/", tagFeedHandler)
+	mux.HandleFunc("/set_tag_public", setTagPublicHandler)
+	mux.HandleFunc("/get_article", getArticleHandler)
+	mux.HandleFunc("/set_feed_full_text", setFeedFullTextHandler)
+	mux.HandleFunc("/get_feed_history", getFeedHistoryHandler)
+	mux.HandleFunc("/set_position", setPositionHandler)
+	mux.HandleFunc("/set_read", setReadHandler)
+	mux.HandleFunc("/get_rules", getRulesHandler)
+	mux.HandleFunc("/add_rule", addRuleHandler)
+	mux.HandleFunc("/mod_rule", modRuleHandler)
+	mux.HandleFunc("/del_rule", delRuleHandler)
+	mux.HandleFunc(websubCallbackPath, websubCallbackHandler)
+	mux.HandleFunc("/get_webhooks", getWebhooksHandler)
+	mux.HandleFunc("/add_webhook", addWebhookHandler)
+	mux.HandleFunc("/del_webhook", delWebhookHandler)
+	mux.HandleFunc("/test_webhook", testWebhookHandler)
+	mux.HandleFunc("/get_webhook_deliveries", getDeliveriesHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func newHTTPServer(conf FeedieServerConfig) *http.Server{
+	return &http.Server{
+		Addr: net.JoinHostPort(conf.Bind, strconv.Itoa(conf.Port)),
+		Handler: logRequests(requireToken(conf.Auth.Token, newServeMux())),
+		ReadTimeout: time.Duration(conf.Timeouts.Read),
+		WriteTimeout: time.Duration(conf.Timeouts.Write),
+		IdleTimeout: time.Duration(conf.Timeouts.Idle),
 	}
 }
 
@@ -31,7 +63,6 @@ func getEntriesHandler (w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	order := timeOrder(DESC)
 	method := r.URL.Query().Get("method")
 	value := r.URL.Query().Get("value")
 
@@ -43,48 +74,128 @@ func getEntriesHandler (w http.ResponseWriter, r *http.Request) {
 	offset, err := strconv.Atoi(offsetStr)
 	if err != nil{offset = 0}
 
-	if r.URL.Query().Has("rev"){ order = ASC}
-
-	var data []FeedieEntry
-	switch(method){
-	case "all":
-		log.Printf("serving /get_entries all feeds\n")
-		data = DBGetAllTimeOrdered(order, limit, offset)
+	sort, order, filter, err := parseEntryOptions(r.URL.Query())
+	if err != nil{
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	case "by_tag":
-		if value == ""{
-			log.Printf("error serving /get_entries tag value empty")
-			http.Error(w, "invalid tag name", http.StatusBadRequest)
+	page := entryPage{Limit: limit, Offset: offset, Sort: sort, Filter: filter}
+	// after and before page with cursors, an empty one starting from either
+	// end of the list
+	paged := r.URL.Query().Has("after") || r.URL.Query().Has("before")
+	cursor := r.URL.Query().Get("after")
+	if r.URL.Query().Has("before"){
+		page.Backward = true
+		cursor = r.URL.Query().Get("before")
+	}
+	if cursor != ""{
+		page.Cursor, err = parseEntryCursor(cursor, sort)
+		if err != nil{
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("serving /get_entries tag=%s\n", value)
-		data = DBGetByTagTimeOrdered(value, order, limit, offset)
-
+	}
 
+	if paged && page.Limit >= 0{
+		page.Limit++
+	}
 
-	case "by_feed":
-		if value == ""{
-			log.Printf("error serving /get_entries feed value empty")
-			http.Error(w, "invalid feed name", http.StatusBadRequest)
+	summary := r.URL.Query().Has("summary")
+	key := entryCacheKey(method, value, order, page, paged, summary)
+	cached, generation, ok := entryCache.get(key)
+	if !ok{
+		data, err := queryEntries(method, value, order, page)
+		if err != nil{
+			slog.WarnContext(r.Context(), "invalid entries query", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		log.Printf("serving /get_entries feed=%s\n", value)
-		data = DBGetByFeedTimeOrdered(DBGetFeedByName(value), order, limit, offset)
+		var cursors entryCursorPage
+		if paged{
+			data, cursors = cutEntryPage(data, page)
+		}
+		var response any = data
+		if summary{
+			response = summarizeEntries(data)
+		}
+		if paged{
+			cursors.Entries = response
+			response = cursors
+		}
+		cached, err = entryCache.put(key, generation, response)
+		if err != nil{
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
-	default:
-		log.Printf("error serving /get_entries invalid method=%s", method)
-		http.Error(w, "invalid method", http.StatusBadRequest)
+	w.Header().Set("ETag", cached.etag)
+	if etagMatches(r.Header.Get("If-None-Match"), cached.etag){
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cached.body)
+}
 
-		if err := json.NewEncoder(w).Encode(data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+// getEntryHandler returns the entry with the given id, hidden or not, along
+// with its extracted article if one was fetched.
+func getEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	entry, ok := DBGetEntry(id)
+	if !ok {
+		http.Error(w, "unknown entry", http.StatusNotFound)
+		return
+	}
+	entry.Article, _ = DBGetArticle(id)
+	// chapters files are fetched once an episode is looked at
+	if p := entry.Podcast; p != nil && p.ChaptersURL != "" && len(p.Chapters) == 0 {
+		if chapters, err := fetchChapters(p.ChaptersURL); err != nil {
+			slog.WarnContext(r.Context(), "unable to fetch chapters", "url", p.ChaptersURL, "err", err)
+		} else {
+			p.Chapters = chapters
+			DBSetChapters(id, chapters)
 		}
+	}
+	writeJSON(w, entry)
+}
 
+// queryEntries runs one of the entry queries selectable by the method
+// parameter of /get_entries and the feed output endpoints.
+func queryEntries(method, value string, order timeOrder, page entryPage) ([]FeedieEntry, error){
+	switch(method){
+	case "all":
+		return DBGetAllTimeOrdered(order, page), nil
 
+	case "by_tag":
+		if value == ""{
+			return nil, errors.New("invalid tag name")
+		}
+		return DBGetByTagTimeOrdered(value, order, page), nil
+
+	case "by_feed":
+		if value == ""{
+			return nil, errors.New("invalid feed name")
+		}
+		return DBGetByFeedTimeOrdered(DBGetFeedByName(value), order, page), nil
 
+	case "search":
+		if value == ""{
+			return nil, errors.New("invalid search query")
+		}
+		return DBSearchTimeOrdered(value, order, page), nil
+	}
+	return nil, fmt.Errorf("invalid method: %s", method)
 }
 
 func getFeedsHandler (w http.ResponseWriter, r *http.Request) {
@@ -101,12 +212,10 @@ func getFeedsHandler (w http.ResponseWriter, r *http.Request) {
 	}
 	switch method {
 	case "all":
-		log.Printf("serving /get_feeds for all feeds\n")
 		data = DBGetFeeds(withEntries)
 	case "by_tag":
 		tagName := r.URL.Query().Get("tag_name")
 		inverted := r.URL.Query().Has("inverted")
-		log.Printf("serving /get_feeds for tag=%s\n", tagName)
 		data = DBGetFeedsByTag(tagName, inverted)
 	}
 
@@ -115,7 +224,7 @@ func getFeedsHandler (w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
 	}
 }
 
@@ -124,23 +233,14 @@ func getTagsHandler (w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	log.Printf("serving /get_tags\n")
-	data := DBGetTags()
-	type src_object struct{
-		Title string `json:"Title"`
-	}
-	objectified := []src_object{}
-	for _, d := range data{
-		objectified = append(objectified, src_object{Title: d})
-		
-	}
+	data := DBGetTagList()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	if err := json.NewEncoder(w).Encode(objectified); err != nil {
+	if err := json.NewEncoder(w).Encode(data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println(err)
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
 	}
 }
 func addFeedHandler (w http.ResponseWriter, r *http.Request) {
@@ -152,12 +252,24 @@ func addFeedHandler (w http.ResponseWriter, r *http.Request) {
 	if url == ""{
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("error serving /add_feed url value empty")
+		slog.WarnContext(r.Context(), "url value empty")
+		return
+	}
+
+	src := FeedieSource{
+		Url: url,
+		Adapter: r.URL.Query().Get("adapter"),
+		Config: json.RawMessage(r.URL.Query().Get("config")),
+	}
+	if src.Adapter != "" && src.Adapter != defaultAdapter && !json.Valid(src.Config){
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid adapter config")
 		return
 	}
 
-	log.Printf("serving /add_feed, url=%s\n", url)
-	if !addFeed(url){
+	if _, err := addFeed(src); err != nil{
+		slog.WarnContext(r.Context(), "unable to add feed", "err", err)
 		http.Error(w, "unable to parse feed", http.StatusBadRequest)
 		return
 	}
@@ -167,14 +279,28 @@ func addFeedHandler (w http.ResponseWriter, r *http.Request) {
 
 }
 
-func addFeed(url string) bool{
-	feed := parser(url)
-	if feed == nil {
-		log.Printf("unable to parse feed: %s", url)
-		return false
+// addFeed fetches the feed src describes and stores it with its entries.
+func addFeed(src FeedieSource) (*FeedieFeed, error){
+	if src.Adapter == "" || src.Adapter == defaultAdapter {
+		url, err := resolveYouTubeURL(src.Url)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve feed url: %w", err)
+		}
+		src.Url = url
+	}
+	feed, err := fetchSource(src)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse feed: %s, %w", src.Url, err)
+	}
+	// subscribe to where the feed lives now
+	if feed.movedTo != "" {
+		feed.Url, src.Url = feed.movedTo, feed.movedTo
 	}
 	DBAddFeedWithEntries(*feed)
-	return true
+	DBSetFeedSource(src)
+	// before returning, so subcommands exiting right after don't drop it
+	websubSubscribe(*feed)
+	return feed, nil
 }
 
 func delFeedHandler (w http.ResponseWriter, r *http.Request) {
@@ -186,12 +312,14 @@ func delFeedHandler (w http.ResponseWriter, r *http.Request) {
 	if url == ""{
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("error serving /del_feed url value empty")
+		slog.WarnContext(r.Context(), "url value empty")
 		return
 	}
 
-	log.Printf("serving /del_feed, url=%s\n", url)
-   DBDelFeed(url)
+	// the hub is asked in the background, the subscription it needs being
+	// read before the feed is deleted
+	websubUnsubscribe(url)
+	DBDelFeed(url)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -206,11 +334,10 @@ func addTagHandler (w http.ResponseWriter, r *http.Request) {
 	if name == ""{
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("error serving /add_tag tag_name value empty")
+		slog.WarnContext(r.Context(), "tag_name value empty")
 		return
 	}
 
-	log.Printf("serving /add_tag, tag_name=%s\n", name)
 	DBAddTag(name)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -226,11 +353,10 @@ func delTagHandler (w http.ResponseWriter, r *http.Request) {
 	if name == ""{
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("error serving /del_tag tag_name value empty")
+		slog.WarnContext(r.Context(), "tag_name value empty")
 		return
 	}
 
-	log.Printf("serving /del_tag, tag_name=%s\n", name)
 	DBDelTag(name)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -246,11 +372,10 @@ func clearTagHandler (w http.ResponseWriter, r *http.Request) {
 	if name == ""{
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		log.Printf("error serving /clear_tag tag_name value empty")
+		slog.WarnContext(r.Context(), "tag_name value empty")
 		return
 	}
 
-	log.Printf("serving /clear_tag, tag_name=%s\n", name)
 	DBClearMembersTag(name)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -268,15 +393,14 @@ func DelTagMemberHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		if tagName == "" {
-			log.Printf("error serving /del_member tag_name value empty")
+			slog.WarnContext(r.Context(), "tag_name value empty")
 		}
 		if feedURL == "" {
-			log.Printf("error serving /del_member feed_url value empty")
+			slog.WarnContext(r.Context(), "feed_url value empty")
 		}
 		return
 	}
 
-	log.Printf("serving /del_member, tag_name=%s feed_url=%s\n", tagName, feedURL)
 	DBDelMembership(tagName, feedURL)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -293,17 +417,140 @@ func AddTagMemberHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		if tagName == "" {
-			log.Printf("error serving /add_member tag_name value empty")
+			slog.WarnContext(r.Context(), "tag_name value empty")
 		}
 		if feedURL == "" {
-			log.Printf("error serving /add_member feed_url value empty")
+			slog.WarnContext(r.Context(), "feed_url value empty")
 		}
 		return
 	}
 
-	log.Printf("serving /add_member, tag_name=%s feed_url=%s\n", tagName, feedURL)
 	DBAddMembership(tagName, feedURL)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
+
+func getRulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	data := DBGetRules()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
+	}
+}
+
+func ruleFromQuery(r *http.Request) FeedieRule {
+	q := r.URL.Query()
+	return FeedieRule{
+		Field:       q.Get("field"),
+		MatchType:   q.Get("match_type"),
+		Pattern:     q.Get("pattern"),
+		Scope:       q.Get("scope"),
+		ScopeValue:  q.Get("scope_value"),
+		Action:      q.Get("action"),
+		ActionValue: q.Get("action_value"),
+	}
+}
+
+func addRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rule := ruleFromQuery(r)
+	id, err := DBAddRule(rule)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid rule", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"ID": id}); err != nil {
+		slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
+	}
+}
+
+func modRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rule := ruleFromQuery(r)
+	rule.ID = r.URL.Query().Get("id")
+	if rule.ID == "" || !DBRuleExists(rule.ID) {
+		slog.WarnContext(r.Context(), "unknown rule", "id", rule.ID)
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return
+	}
+	if _, err := DBAddRule(rule); err != nil {
+		slog.WarnContext(r.Context(), "invalid rule", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func delRuleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "id value empty")
+		return
+	}
+
+	DBDelRule(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// setReadHandler marks the entry with the given id, or the one with the
+// link url, as read or unread.
+func setReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link := r.URL.Query().Get("url")
+	read, err := strconv.ParseBool(r.URL.Query().Get("read"))
+	var entryID int64
+	if v := r.URL.Query().Get("id"); v != "" && err == nil {
+		entryID, err = strconv.ParseInt(v, 10, 64)
+	}
+	if (link == "" && entryID == 0) || err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid url, id or read value")
+		return
+	}
+	if entryID == 0 {
+		entryID = DBGetEntryIDByLink(link)
+	}
+	if entryID == 0 || !DBEntryExists(entryID) {
+		slog.WarnContext(r.Context(), "no such entry", "url", link, "id", r.URL.Query().Get("id"))
+		http.Error(w, "unknown entry", http.StatusNotFound)
+		return
+	}
+
+	DBSetRead(entryID, read)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
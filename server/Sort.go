@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry lists are sorted by publication time unless a query names another
+// of the entrySorts, and can be narrowed down with an entryFilter.
+
+// entrySort is an order entry lists can be sorted in: by the values of
+// columns, then by entry id.
+type entrySort struct {
+	columns []string
+	// key returns the values of columns for an entry
+	key func(FeedieEntry) []any
+}
+
+const defaultEntrySort = "published"
+
+var entrySorts = map[string]entrySort{
+	"published": {
+		columns: []string{"e.published"},
+		key:     func(e FeedieEntry) []any { return []any{e.Published} },
+	},
+	// entries stored before first_seen was recorded were seen at 0, in the
+	// order of their ids
+	"seen": {
+		columns: []string{"e.first_seen"},
+		key:     func(e FeedieEntry) []any { return []any{e.FirstSeen} },
+	},
+	"feed": {
+		columns: []string{"f.title COLLATE NOCASE", "e.published"},
+		key:     func(e FeedieEntry) []any { return []any{e.FeedTitle, e.Published} },
+	},
+	"title": {
+		columns: []string{"e.title COLLATE NOCASE"},
+		key:     func(e FeedieEntry) []any { return []any{e.Title} },
+	},
+}
+
+// entryFilter narrows entry lists down, its zero value letting every entry
+// through.
+type entryFilter struct {
+	// published between Since and Until, inclusive, when they are set
+	Since int64
+	Until int64
+	// Author is part of the entries' author
+	Author string
+	// only entries with enclosures
+	Enclosures bool
+}
+
+// where returns the conditions of f, each starting with AND.
+func (f entryFilter) where() (string, []any) {
+	where := ""
+	args := []any{}
+	if f.Since != 0 {
+		where += `
+AND e.published >= ?`
+		args = append(args, f.Since)
+	}
+	if f.Until != 0 {
+		where += `
+AND e.published <= ?`
+		args = append(args, f.Until)
+	}
+	if f.Author != "" {
+		where += `
+AND e.author LIKE ?`
+		args = append(args, "%"+f.Author+"%")
+	}
+	if f.Enclosures {
+		// the links enclosures returns
+		where += `
+AND EXISTS (SELECT 1 FROM links AS el
+	WHERE el.entry_id = e.id AND COALESCE(el.link_type, '') NOT IN ('', 'text/html'))`
+	}
+	return where, args
+}
+
+// parseEntryOptions reads the sort, order and filters of an entry query.
+// since and until are unix times or dates, until including the whole day.
+func parseEntryOptions(q url.Values) (sort string, order timeOrder, filter entryFilter, err error) {
+	sort = defaultEntrySort
+	if q.Has("sort") {
+		sort = q.Get("sort")
+		if _, ok := entrySorts[sort]; !ok {
+			return "", DESC, filter, fmt.Errorf("invalid sort: %s", sort)
+		}
+	}
+	order = DESC
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		order = ASC
+	default:
+		return "", DESC, filter, fmt.Errorf("invalid order: %s", q.Get("order"))
+	}
+	if q.Has("rev") {
+		order = ASC
+	}
+	if filter.Since, err = parseQueryTime(q.Get("since"), false); err != nil {
+		return "", DESC, filter, err
+	}
+	if filter.Until, err = parseQueryTime(q.Get("until"), true); err != nil {
+		return "", DESC, filter, err
+	}
+	filter.Author = strings.TrimSpace(q.Get("author"))
+	filter.Enclosures = q.Has("enclosures")
+	return sort, order, filter, nil
+}
+
+// parseQueryTime parses a unix time or a date in the server's time zone,
+// returning the end of the day when endOfDay is set. An empty s is 0.
+func parseQueryTime(s string, endOfDay bool) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if t, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1).Unix() - 1, nil
+	}
+	return day.Unix(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntrySorts(t *testing.T) {
+	initTestDB(t)
+	b := numberedFeed("b.example.com", 1000, 3)
+	b.Entries[1].Title = "Apple"
+	DBAddFeedWithEntries(b)
+	a := numberedFeed("a.example.com", 2000, 2)
+	a.Entries[0].Title = "zebra"
+	DBAddFeedWithEntries(a)
+
+	// entries of a were seen after those of b, and have the greater ids
+	for sort, check := range map[string]func(prev, e FeedieEntry) bool{
+		"published": func(prev, e FeedieEntry) bool { return prev.Published < e.Published },
+		"seen":      func(prev, e FeedieEntry) bool { return prev.FirstSeen <= e.FirstSeen && prev.ID < e.ID },
+		"feed": func(prev, e FeedieEntry) bool {
+			return prev.FeedTitle < e.FeedTitle || prev.FeedTitle == e.FeedTitle && prev.Published < e.Published
+		},
+		"title": func(prev, e FeedieEntry) bool { return strings.ToLower(prev.Title) <= strings.ToLower(e.Title) },
+	} {
+		entries := DBGetAllTimeOrdered(ASC, entryPage{Limit: -1, Sort: sort})
+		for i := 1; i < len(entries); i++ {
+			if !check(entries[i-1], entries[i]) {
+				t.Errorf("sorted by %s, %q of %s comes before %q of %s", sort,
+					entries[i-1].Title, entries[i-1].FeedTitle, entries[i].Title, entries[i].FeedTitle)
+			}
+		}
+
+		// cursors page through the sort in both orders
+		for _, order := range []string{"asc", "desc"} {
+			want := []int64{}
+			for _, e := range DBGetAllTimeOrdered(order == "asc", entryPage{Limit: -1, Sort: sort}) {
+				want = append(want, e.ID)
+			}
+			query := url.Values{"method": {"all"}, "summary": {""}, "limit": {"2"}, "sort": {sort}, "order": {order}, "after": {""}}
+			got := []int64{}
+			for {
+				ids, next, _ := getEntryPage(t, query)
+				got = append(got, ids...)
+				if next == "" {
+					break
+				}
+				query.Set("after", next)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("paging by %s %s got %v, want %v", sort, order, got, want)
+			}
+		}
+	}
+
+	_, next, _ := getEntryPage(t, url.Values{"method": {"all"}, "limit": {"2"}, "sort": {"title"}, "after": {""}})
+	for _, query := range []string{"sort=nope", "order=up", "since=yesterday",
+		"sort=published&after=" + next} {
+		rec := httptest.NewRecorder()
+		getEntriesHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entries?method=all&"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s answered %d", query, rec.Code)
+		}
+	}
+}
+
+func TestEntryFilters(t *testing.T) {
+	initTestDB(t)
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local).Unix()
+	feed := numberedFeed("a.example.com", day-2*24*60*60, 4)
+	feed.Entries[0].Author = "Ada Lovelace"
+	feed.Entries[1].Links = append(feed.Entries[1].Links, FeedieLink{URL: "http://a.example.com/2.mp3", Type: "audio/mpeg"})
+	feed.Entries[2].Published = day
+	DBAddFeedWithEntries(feed)
+
+	for query, want := range map[string][]string{
+		"since=2024-03-10":                               {"3"},
+		"until=2024-03-09":                               {"1", "2", "4"},
+		"since=2024-03-08&until=2024-03-08":              {"1", "2", "4"},
+		"since=" + fmt.Sprint(feed.Entries[1].Published): {"2", "3", "4"},
+		"author=lovelace":                                {"1"},
+		"enclosures":                                     {"2"},
+		"enclosures&author=ada":                          {},
+	} {
+		q, _ := url.ParseQuery(query)
+		_, _, filter, err := parseEntryOptions(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, e := range DBGetByFeedTimeOrdered(feed, ASC, entryPage{Limit: -1, Filter: filter}) {
+			got = append(got, e.GUID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s got %v, want %v", query, got, want)
+		}
+	}
+}
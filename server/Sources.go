@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mmcdole/gofeed"
+)
+
+// A source adapter fetches a feed from somewhere and turns it into a
+// FeedieFeed. RSS/Atom is the default adapter; the others are configured per
+// feed with a JSON document stored in the feed_sources table.
+
+type SourceAdapter interface {
+	Fetch(src FeedieSource) (*FeedieFeed, error)
+}
+
+type FeedieSource struct {
+	Url     string
+	Adapter string
+	Config  json.RawMessage
+}
+
+const defaultAdapter = "rss"
+
+// fetchTimeout is how long fetching a feed or page may take.
+func fetchTimeout() time.Duration {
+	if feedieServer == nil {
+		return 60 * time.Second
+	}
+	return time.Duration(feedieServer.config.Timeouts.Fetch)
+}
+
+var sourceAdapters = map[string]SourceAdapter{
+	"rss":     rssAdapter{},
+	"html":    htmlAdapter{},
+	"json":    jsonAdapter{},
+	"command": commandAdapter{},
+}
+
+// the command adapter runs programs on the server, so it has to be enabled
+// by whoever runs the server rather than by anyone able to add a feed
+func commandsAllowed() bool {
+	return feedieServer != nil && feedieServer.config.AllowCommands
+}
+
+func getAdapter(name string) (SourceAdapter, error) {
+	if name == "" {
+		name = defaultAdapter
+	}
+	adapter, ok := sourceAdapters[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source adapter: %s", name)
+	}
+	if name == "command" && !commandsAllowed() {
+		return nil, errors.New("command adapter is disabled, set allow_commands in the server config to enable it")
+	}
+	return adapter, nil
+}
+
+func fetchSource(src FeedieSource) (*FeedieFeed, error) {
+	adapter, err := getAdapter(src.Adapter)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	feed, err := adapter.Fetch(src)
+	feedFetchDuration.WithLabelValues(src.Url).Observe(time.Since(start).Seconds())
+	if err != nil {
+		feedFetchFailures.WithLabelValues(src.Url).Inc()
+	}
+	return feed, err
+}
+
+type rssAdapter struct{}
+
+func (rssAdapter) Fetch(src FeedieSource) (*FeedieFeed, error) {
+	resp, err := fetchPage(src.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	parsed := convertFeed(feed, src.Url)
+	parsed.hub, parsed.topic = discoverHub(resp.Header, body)
+	parsed.movedTo = permanentRedirect(resp)
+	return parsed, nil
+}
+
+// commandAdapter runs a program and parses the RSS/Atom document it prints.
+type commandAdapter struct{}
+
+type commandConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+func (commandAdapter) Fetch(src FeedieSource) (*FeedieFeed, error) {
+	var conf commandConfig
+	if err := json.Unmarshal(src.Config, &conf); err != nil {
+		return nil, fmt.Errorf("invalid command adapter config: %w", err)
+	}
+	if conf.Command == "" {
+		return nil, errors.New("command adapter requires a command")
+	}
+	ctx, cancel := context.WithTimeout(serverContext(), fetchTimeout())
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, conf.Command, conf.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", conf.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	feed, err := gofeed.NewParser().Parse(&stdout)
+	if err != nil {
+		return nil, err
+	}
+	return convertFeed(feed, src.Url), nil
+}
+
+// fieldMapping maps entry fields to selectors (html adapter) or JSONPath
+// expressions (json adapter) evaluated against each item.
+type fieldMapping struct {
+	FeedTitle   string `json:"feed_title"`
+	Items       string `json:"items"`
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	Date        string `json:"date"`
+	Thumbnail   string `json:"thumbnail"`
+	GUID        string `json:"guid"`
+}
+
+func (m fieldMapping) validate() error {
+	if m.Items == "" || m.Title == "" {
+		return errors.New("adapter config requires items and title")
+	}
+	return nil
+}
+
+// newSourceEntry builds an entry from extracted field values. Entries
+// without a GUID are identified by their link, or by their title and author
+// without one: an undated entry is given the time it was fetched, which
+// would make it a new entry on every refresh.
+func newSourceEntry(src FeedieSource, feedTitle string, f map[string]string) FeedieEntry {
+	entry := newEmptyEntry()
+	entry.Title = f["title"]
+	entry.Author = f["author"]
+	if entry.Author == "" {
+		entry.Author = feedTitle
+	}
+	entry.Description = f["description"]
+	entry.Thumbnail = f["thumbnail"]
+	entry.GUID = f["guid"]
+	if entry.GUID == "" {
+		entry.GUID = f["link"]
+	}
+	if entry.GUID == "" {
+		entry.GUID = entry.Title + "\n" + entry.Author
+	}
+	if f["date"] != "" {
+		var dated bool
+		entry.Published, dated = parsePublished(f["date"], src.Url)
+		entry.undated = !dated
+	} else {
+		entry.Published, entry.undated = time.Now().Unix(), true
+	}
+	if f["link"] != "" {
+		entry.Links = append(entry.Links, FeedieLink{URL: f["link"], Type: "text/html"})
+	}
+	return *entry
+}
+
+// htmlAdapter scrapes a web page using CSS selectors. A selector may end in
+// @attr to read an attribute instead of the element's text; link and
+// thumbnail urls are resolved against the page url.
+type htmlAdapter struct{}
+
+func (htmlAdapter) Fetch(src FeedieSource) (*FeedieFeed, error) {
+	var conf fieldMapping
+	if err := json.Unmarshal(src.Config, &conf); err != nil {
+		return nil, fmt.Errorf("invalid html adapter config: %w", err)
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	resp, err := fetchPage(src.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	base := resp.Request.URL
+
+	feedTitle := strings.TrimSpace(doc.Find("title").First().Text())
+	if conf.FeedTitle != "" {
+		feedTitle = selectValue(doc.Selection, conf.FeedTitle, base)
+	}
+	entries := []FeedieEntry{}
+	doc.Find(conf.Items).Each(func(_ int, item *goquery.Selection) {
+		fields := map[string]string{
+			"title":       selectValue(item, conf.Title, base),
+			"link":        selectValue(item, conf.Link, base),
+			"author":      selectValue(item, conf.Author, base),
+			"date":        selectValue(item, conf.Date, base),
+			"thumbnail":   selectValue(item, conf.Thumbnail, base),
+			"guid":        selectValue(item, conf.GUID, base),
+			"description": selectHTML(item, conf.Description),
+		}
+		if fields["title"] == "" {
+			return
+		}
+		entries = append(entries, newSourceEntry(src, feedTitle, fields))
+	})
+	feed := newFeed(feedTitle, src.Url, entries)
+	feed.movedTo = permanentRedirect(resp)
+	return feed, nil
+}
+
+// selectValue returns the text, or attribute for selectors ending in
+// @attr, of the first element matching selector. An empty selector or
+// a bare @attr selects the item itself.
+func selectValue(s *goquery.Selection, selector string, base *url.URL) string {
+	if selector == "" {
+		return ""
+	}
+	sel, attr, hasAttr := strings.Cut(selector, "@")
+	target := s
+	if strings.TrimSpace(sel) != "" {
+		target = s.Find(sel).First()
+	}
+	if !hasAttr {
+		return strings.TrimSpace(target.Text())
+	}
+	v := strings.TrimSpace(target.AttrOr(attr, ""))
+	if v != "" && (attr == "href" || attr == "src") {
+		if ref, err := url.Parse(v); err == nil {
+			v = base.ResolveReference(ref).String()
+		}
+	}
+	return v
+}
+
+func selectHTML(s *goquery.Selection, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	content, err := s.Find(selector).First().Html()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(content)
+}
+
+// jsonAdapter reads items from a JSON API using JSONPath expressions. Items
+// is evaluated against the document, the other fields against each item.
+type jsonAdapter struct{}
+
+func (jsonAdapter) Fetch(src FeedieSource) (*FeedieFeed, error) {
+	var conf fieldMapping
+	if err := json.Unmarshal(src.Config, &conf); err != nil {
+		return nil, fmt.Errorf("invalid json adapter config: %w", err)
+	}
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	resp, err := fetchPage(src.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var doc any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	feedTitle := src.Url
+	if conf.FeedTitle != "" {
+		feedTitle = jsonPathString(doc, conf.FeedTitle)
+	}
+	items, err := jsonPath(doc, conf.Items)
+	if err != nil {
+		return nil, err
+	}
+	// a path without a wildcard yields the array itself
+	if len(items) == 1 {
+		if arr, ok := items[0].([]any); ok {
+			items = arr
+		}
+	}
+	entries := []FeedieEntry{}
+	for _, item := range items {
+		fields := map[string]string{
+			"title":       jsonPathString(item, conf.Title),
+			"link":        jsonPathString(item, conf.Link),
+			"author":      jsonPathString(item, conf.Author),
+			"date":        jsonPathString(item, conf.Date),
+			"thumbnail":   jsonPathString(item, conf.Thumbnail),
+			"guid":        jsonPathString(item, conf.GUID),
+			"description": jsonPathString(item, conf.Description),
+		}
+		if fields["title"] == "" {
+			continue
+		}
+		entries = append(entries, newSourceEntry(src, feedTitle, fields))
+	}
+	feed := newFeed(feedTitle, src.Url, entries)
+	feed.movedTo = permanentRedirect(resp)
+	return feed, nil
+}
+
+// jsonPath evaluates the subset of JSONPath needed for feed mappings:
+// $, .key, ['key'], [n] and [*].
+func jsonPath(doc any, path string) ([]any, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSONPath %q: must start with $", path)
+	}
+	cur := []any{doc}
+	rest := path[1:]
+	for rest != "" {
+		var step string
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			step, rest = rest[:end], rest[end:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSONPath %q: unclosed [", path)
+			}
+			step, rest = rest[1:end], rest[end+1:]
+			step = strings.Trim(step, `'"`)
+		default:
+			return nil, fmt.Errorf("invalid JSONPath %q", path)
+		}
+		next := []any{}
+		for _, v := range cur {
+			switch node := v.(type) {
+			case map[string]any:
+				if step == "*" {
+					for _, child := range node {
+						next = append(next, child)
+					}
+				} else if child, ok := node[step]; ok {
+					next = append(next, child)
+				}
+			case []any:
+				if step == "*" {
+					next = append(next, node...)
+				} else if i, err := strconv.Atoi(step); err == nil && i >= 0 && i < len(node) {
+					next = append(next, node[i])
+				}
+			}
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// jsonPathString returns the first value path selects as a string, or ""
+// if it selects nothing or the path is invalid.
+func jsonPathString(doc any, path string) string {
+	if path == "" {
+		return ""
+	}
+	values, err := jsonPath(doc, path)
+	if err != nil {
+		slog.Debug("json path did not match", "path", path, "err", err)
+		return ""
+	}
+	if len(values) == 0 || values[0] == nil {
+		return ""
+	}
+	switch v := values[0].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	b, _ := json.Marshal(values[0])
+	return string(b)
+}
+
+func DBGetFeedSource(feedURL string) FeedieSource {
+	src := FeedieSource{Url: feedURL, Adapter: defaultAdapter}
+	var config string
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT s.adapter, s.config FROM feed_sources AS s
+JOIN feeds AS f ON f.id = s.feed_id
+WHERE f.url = ?`, feedURL).Scan(&src.Adapter, &config)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return src
+		}
+		log.Fatal(err)
+	}
+	src.Config = json.RawMessage(config)
+	return src
+}
+
+// DBSetFeedSource records the adapter of a feed that was already added.
+// Feeds using the default adapter don't need a row.
+func DBSetFeedSource(src FeedieSource) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	var err error
+	if src.Adapter == "" || src.Adapter == defaultAdapter {
+		_, err = db.Exec(`DELETE FROM feed_sources
+WHERE feed_id = (SELECT id FROM feeds WHERE url = ?)`, src.Url)
+	} else {
+		_, err = db.Exec(`INSERT INTO feed_sources (feed_id, adapter, config)
+SELECT id, ?, ? FROM feeds WHERE url = ?
+ON CONFLICT(feed_id) DO UPDATE SET
+    adapter = excluded.adapter,
+    config = excluded.config;`, src.Adapter, string(src.Config), src.Url)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUndatedSourceEntriesKeepTheirIdentity(t *testing.T) {
+	initTestDB(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Notices</title></head><body>
+<div class="notice"><h2>Closed monday</h2><span class="by">Office</span></div>
+<div class="notice"><h2>New hours</h2></div>
+</body></html>`)
+	}))
+	defer srv.Close()
+	conf, _ := json.Marshal(fieldMapping{Items: ".notice", Title: "h2", Author: ".by"})
+	src := FeedieSource{Url: srv.URL, Adapter: "html", Config: conf}
+
+	fetch := func() FeedieFeed {
+		feed, err := htmlAdapter{}.Fetch(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *feed
+	}
+	first := fetch()
+	DBAddFeedWithEntries(first)
+	stored := map[string]int64{}
+	for _, e := range DBGetByFeedTimeOrdered(first, ASC, allEntries) {
+		stored[e.Title] = e.Published
+	}
+
+	// a later refresh gives the entries a later time
+	again := fetch()
+	for i := range again.Entries {
+		again.Entries[i].Published += 3600
+	}
+	DBAddFeedWithEntries(again)
+	entries := DBGetByFeedTimeOrdered(again, ASC, allEntries)
+	if len(entries) != 2 {
+		t.Fatalf("refreshing stored %d entries, want 2", len(entries))
+	}
+	for _, e := range entries {
+		if e.Published != stored[e.Title] {
+			t.Errorf("%q was published at %d, then %d", e.Title, stored[e.Title], e.Published)
+		}
+	}
+}
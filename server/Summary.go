@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Entry lists only need enough of each entry to show it in the list, so
+// /get_entries?summary leaves out descriptions and links. Clients fetch the
+// entry selected with /get_entry.
+
+// excerptLength is the number of characters of an entry's description kept
+// in its summary.
+const excerptLength = 200
+
+type FeedieEntrySummary struct {
+	ID         int64
+	Title      string
+	Author     string
+	Published  int64
+	Thumbnail  string
+	LinkCount  int
+	Excerpt    string
+	Read       bool
+	Starred    bool
+	FeedID     int64
+	FeedTitle  string
+	FaviconURL string
+}
+
+func summarizeEntries(entries []FeedieEntry) []FeedieEntrySummary {
+	ret := make([]FeedieEntrySummary, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, FeedieEntrySummary{
+			ID:         e.ID,
+			Title:      e.Title,
+			Author:     e.Author,
+			Published:  e.Published,
+			Thumbnail:  e.Thumbnail,
+			LinkCount:  len(e.Links),
+			Excerpt:    excerpt(e.Description, excerptLength),
+			Read:       e.Read,
+			Starred:    e.Starred,
+			FeedID:     e.FeedID,
+			FeedTitle:  e.FeedTitle,
+			FaviconURL: e.FaviconURL,
+		})
+	}
+	return ret
+}
+
+// excerpt returns the text of the html document description with its
+// whitespace collapsed, cut after n characters.
+func excerpt(description string, n int) string {
+	text := description
+	if doc, err := goquery.NewDocumentFromReader(strings.NewReader(description)); err == nil {
+		// keep the words of consecutive paragraphs apart
+		doc.Find("p, div, br, li, tr, h1, h2, h3, h4, h5, h6, blockquote").AfterHtml(" ")
+		text = doc.Text()
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
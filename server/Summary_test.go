@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetEntriesSummary(t *testing.T) {
+	initTestDB(t)
+	feed := numberedFeed("a.example.com", 1000, 1)
+	feed.Entries[0].Description = "<p>Hello <b>there</b>,\n\n&amp; welcome.</p>" + strings.Repeat("<p>more words</p>", 50)
+	feed.Entries[0].Links = append(feed.Entries[0].Links, FeedieLink{URL: "http://a.example.com/1.mp3", Type: "audio/mpeg"})
+	DBAddFeedWithEntries(feed)
+
+	rec := httptest.NewRecorder()
+	getEntriesHandler(rec, httptest.NewRequest(http.MethodGet, "/get_entries?method=all&summary", nil))
+	var raw []map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 {
+		t.Fatalf("%d summaries, want 1", len(raw))
+	}
+	for _, field := range []string{"Description", "Links"} {
+		if _, ok := raw[0][field]; ok {
+			t.Errorf("summary has %s", field)
+		}
+	}
+
+	s := summarizeEntries(DBGetAllTimeOrdered(DESC, allEntries))[0]
+	if s.ID == 0 || s.LinkCount != 2 || s.FeedTitle != "a.example.com" {
+		t.Errorf("summary %+v", s)
+	}
+	if !strings.HasPrefix(s.Excerpt, "Hello there, & welcome. more words more words") ||
+		!strings.HasSuffix(s.Excerpt, "…") || len([]rune(s.Excerpt)) > excerptLength+1 {
+		t.Errorf("excerpt %q", s.Excerpt)
+	}
+}
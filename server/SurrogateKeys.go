@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+)
+
+// Databases from before integer ids keyed feeds, entries, links and tags by
+// the FNV hash of their url, GUID or name. They are rebuilt when opened:
+// every table holding one of those keys is renamed, created again with
+// integer ids and copied over, the old keys going into the hash columns.
+
+// rebuiltTables are the tables copied by migrateSurrogateKeys, each after
+// the tables it refers to.
+var rebuiltTables = []struct {
+	name string
+	copy string
+}{
+	{"feeds", `INSERT OR IGNORE INTO feeds (hash, title, url, full_text, dead)
+SELECT id, title, url, full_text, dead FROM feeds_old ORDER BY rowid`},
+	// entries without a GUID were identified by title, author and date
+	{"entries", `INSERT OR IGNORE INTO entries
+(hash, feed_id, title, author, published, description, thumbnail, guid, read, starred, hidden)
+SELECT e.id, f.id, e.title, e.author, e.published, e.description, e.thumbnail,
+	COALESCE(NULLIF(e.guid, ''), COALESCE(e.title, '') || COALESCE(e.author, '') || COALESCE(e.published, '')),
+	e.read, e.starred, e.hidden
+FROM entries_old AS e
+JOIN feeds AS f ON f.hash = e.feed_id
+ORDER BY e.rowid`},
+	{"links", `INSERT OR IGNORE INTO links (url, entry_id, link_type)
+SELECT l.url, e.id, l.link_type
+FROM links_old AS l
+JOIN entries AS e ON e.hash = l.entry_id
+ORDER BY l.rowid`},
+	{"tags", `INSERT OR IGNORE INTO tags (hash, name, public)
+SELECT id, name, public FROM tags_old ORDER BY rowid`},
+	{"tag_members", `INSERT OR IGNORE INTO tag_members (tag_id, feed_id)
+SELECT t.id, f.id
+FROM tag_members_old AS tm
+JOIN tags AS t ON t.hash = tm.tag_id
+JOIN feeds AS f ON f.hash = tm.feed_id`},
+	{"entry_tags", `INSERT OR IGNORE INTO entry_tags (tag_id, entry_id)
+SELECT t.id, e.id
+FROM entry_tags_old AS et
+JOIN tags AS t ON t.hash = et.tag_id
+JOIN entries AS e ON e.hash = et.entry_id`},
+	{"articles", `INSERT OR IGNORE INTO articles (entry_id, content, fetched)
+SELECT e.id, a.content, a.fetched
+FROM articles_old AS a
+JOIN entries AS e ON e.hash = a.entry_id`},
+	{"podcasts", `INSERT OR IGNORE INTO podcasts
+(entry_id, duration, size, episode, season, chapters_url, chapters, position)
+SELECT e.id, p.duration, p.size, p.episode, p.season, p.chapters_url, p.chapters, p.position
+FROM podcasts_old AS p
+JOIN entries AS e ON e.hash = p.entry_id`},
+	{"videos", `INSERT OR IGNORE INTO videos
+(entry_id, views, rating_average, rating_count, duration)
+SELECT e.id, v.views, v.rating_average, v.rating_count, v.duration
+FROM videos_old AS v
+JOIN entries AS e ON e.hash = v.entry_id`},
+	{"feed_sources", `INSERT OR IGNORE INTO feed_sources (feed_id, adapter, config)
+SELECT f.id, s.adapter, s.config
+FROM feed_sources_old AS s
+JOIN feeds AS f ON f.hash = s.feed_id`},
+	{"websub", `INSERT OR IGNORE INTO websub
+(feed_id, hub, topic, secret, state, lease_expires)
+SELECT f.id, w.hub, w.topic, w.secret, w.state, w.lease_expires
+FROM websub_old AS w
+JOIN feeds AS f ON f.hash = w.feed_id`},
+	{"webhook_deliveries", `INSERT INTO webhook_deliveries
+(id, webhook_id, entry_id, attempt, status, error, time)
+SELECT d.id, d.webhook_id, COALESCE(e.id, 0), d.attempt, d.status, d.error, d.time
+FROM webhook_deliveries_old AS d
+LEFT JOIN entries AS e ON e.hash = d.entry_id`},
+	{"feed_history", `INSERT INTO feed_history (feed_id, time, event, detail)
+SELECT f.id, h.time, h.event, h.detail
+FROM feed_history_old AS h
+JOIN feeds AS f ON f.hash = h.feed_id
+ORDER BY h.id`},
+}
+
+// migrateSurrogateKeys rebuilds a database keyed by hashes in one
+// transaction. Callers must hold dbMu.
+func migrateSurrogateKeys() {
+	slog.Info("migrating the database to integer ids")
+	// columns added to the first schema over time, which the copy reads
+	ensureColumn("feeds", "full_text", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("feeds", "dead", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("entries", "read", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("entries", "starred", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("entries", "hidden", "INTEGER NOT NULL DEFAULT 0")
+	ensureColumn("entries", "guid", "TEXT")
+	ensureColumn("tags", "public", "INTEGER NOT NULL DEFAULT 0")
+
+	// the tables are renamed and dropped while others still refer to
+	// them, which needs foreign keys off, a setting of the connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		log.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exec := func(query string) {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+	}
+	// tables added after the first schema may be missing
+	existing := map[string]bool{}
+	for _, t := range rebuiltTables {
+		var exists bool
+		err := tx.QueryRow(`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?`, t.name).
+			Scan(&exists)
+		if err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+		if exists {
+			exec(`ALTER TABLE ` + t.name + ` RENAME TO ` + t.name + `_old`)
+			existing[t.name] = true
+		}
+	}
+	createTables(tx)
+	for _, t := range rebuiltTables {
+		if existing[t.name] {
+			exec(t.copy)
+			exec(`DROP TABLE ` + t.name + `_old`)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+
+	var feeds, entries int
+	err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM feeds), (SELECT COUNT(*) FROM entries)`).Scan(&feeds, &entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("migrated the database to integer ids", "feeds", feeds, "entries", entries)
+}
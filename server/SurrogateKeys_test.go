@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateSurrogateKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedie.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedURL := "http://old.example.com/feed"
+	entry := newEntry("a", "author", 1000, "", "")
+	entryHash := GetHashString(entry.getHashString())
+	// entries with a GUID were keyed by its hash, but the GUID wasn't stored
+	guided := newEntry("b", "author", 2000, "", "")
+	guided.GUID = "tag:old.example.com,2024:b"
+	guidedHash := GetHashString(guided.GUID)
+	// the schema of the first version, keyed by hashes
+	for _, statement := range []string{
+		`CREATE TABLE feeds (id TEXT PRIMARY KEY, title TEXT, url TEXT)`,
+		`CREATE TABLE entries (id TEXT PRIMARY KEY, feed_id TEXT NOT NULL, title TEXT, author TEXT,
+			published INTEGER, description TEXT, thumbnail TEXT)`,
+		`CREATE TABLE links (id TEXT PRIMARY KEY, url TEXT NOT NULL, entry_id TEXT NOT NULL, link_type TEXT)`,
+		`CREATE TABLE tags (id TEXT PRIMARY KEY, name TEXT)`,
+		`CREATE TABLE tag_members (tag_id TEXT NOT NULL, feed_id TEXT NOT NULL, PRIMARY KEY (tag_id, feed_id))`,
+	} {
+		if _, err := old.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inserts := []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO feeds VALUES (?, 'Old', ?)`, []any{GetHashString(feedURL), feedURL}},
+		{`INSERT INTO entries VALUES (?, ?, 'a', 'author', 1000, '', '')`, []any{entryHash, GetHashString(feedURL)}},
+		{`INSERT INTO links VALUES ('l', ?, ?, 'text/html')`, []any{feedURL + "/a", entryHash}},
+		{`INSERT INTO entries VALUES (?, ?, 'b', 'author', 2000, '', '')`, []any{guidedHash, GetHashString(feedURL)}},
+		{`INSERT INTO tags VALUES (?, 'news')`, []any{GetHashString("news")}},
+		{`INSERT INTO tag_members VALUES (?, ?)`, []any{GetHashString("news"), GetHashString(feedURL)}},
+	}
+	for _, i := range inserts {
+		if _, err := old.Exec(i.query, i.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	DBInit(path)
+	t.Cleanup(func() { db.Close() })
+
+	feeds := DBGetFeedsByTag("news", false)
+	if len(feeds) != 1 || feeds[0].ID == 0 || feeds[0].Url != feedURL {
+		t.Fatalf("feeds in the tag after migrating: %+v", feeds)
+	}
+	entries := DBGetByFeedTimeOrdered(feeds[0], ASC, allEntries)
+	if len(entries) != 2 || entries[0].ID == 0 || entries[0].hash != entryHash || len(entries[0].Links) != 1 ||
+		entries[1].hash != guidedHash {
+		t.Fatalf("entries after migrating: %+v", entries)
+	}
+	DBSetRead(entries[0].ID, true)
+	DBSetRead(entries[1].ID, true)
+
+	// refreshing finds the migrated entries instead of adding them again,
+	// the one with a GUID taking it
+	feed := *newFeed("Old", feedURL, []FeedieEntry{*entry, *guided})
+	feed.Entries[0].Links = []FeedieLink{{URL: feedURL + "/a", Type: "text/html"}}
+	DBAddFeedWithEntries(feed)
+	DBAddFeedWithEntries(feed)
+	entries = DBGetByFeedTimeOrdered(feeds[0], ASC, allEntries)
+	if len(entries) != 2 || !entries[0].Read || len(entries[0].Links) != 1 ||
+		!entries[1].Read || entries[1].GUID != guided.GUID || entries[1].hash != guidedHash {
+		t.Errorf("entries after refreshing: %+v", entries)
+	}
+	if problems := DBCheck(); len(problems) != 0 {
+		t.Errorf("database check: %v", problems)
+	}
+}
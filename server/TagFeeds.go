@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags marked public are re-published as RSS 2.0, Atom and JSON Feed
+// documents under /feeds/tag/{name}.rss, .atom and .json.
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link,omitempty"`
+	Description string         `xml:"description"`
+	Author      string         `xml:"dc:creator,omitempty"`
+	PubDate     string         `xml:"pubDate,omitempty"`
+	GUID        rssGUID        `xml:"guid"`
+	Enclosures  []rssEnclosure `xml:"enclosure"`
+}
+
+type atomDoc struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Links     []atomLink  `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+// outputGUID returns the identifier an entry is re-published under and
+// whether it is a permalink. GUIDs that aren't URLs are only unique within
+// their source feed, so those entries are identified by their id instead.
+func (e FeedieEntry) outputGUID() (string, bool) {
+	if strings.HasPrefix(e.GUID, "http://") || strings.HasPrefix(e.GUID, "https://") {
+		return e.GUID, true
+	}
+	return "urn:feedie:entry:" + e.hash, false
+}
+
+func newRSSDoc(title, selfURL string, entries []FeedieEntry) rssDoc {
+	doc := rssDoc{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        selfURL,
+			Description: title,
+		},
+	}
+	for _, e := range entries {
+		guid, permalink := e.outputGUID()
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.primaryLink(),
+			Description: e.Description,
+			Author:      e.Author,
+			GUID:        rssGUID{IsPermaLink: permalink, Value: guid},
+		}
+		if e.Published > 0 {
+			item.PubDate = time.Unix(e.Published, 0).UTC().Format(time.RFC1123Z)
+		}
+		for _, l := range e.enclosures() {
+			item.Enclosures = append(item.Enclosures, rssEnclosure{URL: l.URL, Type: l.Type})
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return doc
+}
+
+func newAtomDoc(title, selfURL string, entries []FeedieEntry) atomDoc {
+	doc := atomDoc{
+		Title: title,
+		ID:    selfURL,
+		Links: []atomLink{{Href: selfURL, Rel: "self", Type: "application/atom+xml"}},
+	}
+	var updated int64
+	for _, e := range entries {
+		guid, _ := e.outputGUID()
+		entry := atomEntry{
+			Title:   e.Title,
+			ID:      guid,
+			Content: atomContent{Type: "html", Value: e.Description},
+		}
+		if e.Published > 0 {
+			entry.Published = time.Unix(e.Published, 0).UTC().Format(time.RFC3339)
+			updated = max(updated, e.Published)
+		}
+		entry.Updated = time.Unix(max(e.Published, 0), 0).UTC().Format(time.RFC3339)
+		if e.Author != "" {
+			entry.Author = &atomAuthor{Name: e.Author}
+		}
+		if link := e.primaryLink(); link != "" {
+			entry.Links = append(entry.Links, atomLink{Href: link, Rel: "alternate", Type: "text/html"})
+		}
+		for _, l := range e.enclosures() {
+			entry.Links = append(entry.Links, atomLink{Href: l.URL, Rel: "enclosure", Type: l.Type})
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	doc.Updated = time.Unix(updated, 0).UTC().Format(time.RFC3339)
+	return doc
+}
+
+func tagFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file := strings.TrimPrefix(r.URL.Path, "/feeds/tag/")
+	dot := strings.LastIndex(file, ".")
+	if dot <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	name, format := file[:dot], file[dot+1:]
+
+	// unshared tags are reported as missing so their names aren't leaked
+	if exists, public := DBGetTagPublic(name); !exists || !public {
+		slog.WarnContext(r.Context(), "tag is not public", "tag", name)
+		http.NotFound(w, r)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = defaultOutputLimit
+	}
+
+	entries := DBGetByTagTimeOrdered(name, DESC, entryPage{Limit: limit})
+	title := outputTitle("by_tag", name)
+	self := requestURL(r)
+
+	switch format {
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		writeXML(w, newRSSDoc(title, self, entries))
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		writeXML(w, newAtomDoc(title, self, entries))
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(newJSONFeed(title, self, entries)); err != nil {
+			slog.ErrorContext(r.Context(), "unable to encode response", "err", err)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeXML(w http.ResponseWriter, doc any) {
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		slog.Error("unable to write response", "err", err)
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		slog.Error("unable to encode response", "err", err)
+	}
+}
+
+func setTagPublicHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("tag_name")
+	public, err := strconv.ParseBool(r.URL.Query().Get("public"))
+	if name == "" || err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "invalid tag_name or public value")
+		return
+	}
+
+	DBSetTagPublic(name, public)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
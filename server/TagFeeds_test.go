@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOnlyPublicTagsArePublished(t *testing.T) {
+	initTestDB(t)
+	feed := testFeed("http://a.example.com/feed", "one", "two")
+	DBAddFeedWithEntries(feed)
+	DBAddTag("shared")
+	DBAddMembership("shared", feed.Url)
+
+	get := func(path string, handler http.HandlerFunc) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	paths := map[string]http.HandlerFunc{
+		"/feeds/tag/shared.rss":                  tagFeedHandler,
+		"/json_feed?method=by_tag&value=shared":  jsonFeedHandler,
+		"/json_feed?method=by_tag&value=missing": jsonFeedHandler,
+	}
+	for path, handler := range paths {
+		if code := get(path, handler); code != http.StatusNotFound {
+			t.Errorf("%s of a private tag answered %d", path, code)
+		}
+	}
+
+	DBSetTagPublic("shared", true)
+	for _, path := range []string{"/feeds/tag/shared.rss", "/json_feed?method=by_tag&value=shared"} {
+		if code := get(path, paths[path]); code != http.StatusOK {
+			t.Errorf("%s of a public tag answered %d", path, code)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Feeds that advertise a WebSub hub are subscribed to so new entries are
+// pushed to /websub/callback/<feed id> instead of waiting for the next
+// refresh. Feeds with an active subscription are skipped by the refresh
+// thread; when subscribing fails or a lease runs out the feed is polled
+// again, and the next poll tries to subscribe again. WebSub needs the
+// server to be reachable from the hub, so it is only enabled when
+// public_url is set in the config.
+
+const websubCallbackPath = "/websub/callback/"
+
+// lease requested from hubs, which may grant a different one
+const websubLease = 10 * 24 * 60 * 60
+
+// subscriptions expiring within websubRenewBefore are renewed
+const websubRenewBefore = time.Hour
+const websubRenewCheck = 5 * time.Minute
+
+const websubTimeout = 30 * time.Second
+
+// maximum size of a pushed feed document
+const websubMaxBody = 10 << 20
+
+const (
+	websubPending    = "pending"
+	websubSubscribed = "subscribed"
+	websubFailed     = "failed"
+)
+
+type websubSub struct {
+	// the feed's hash rather than its id, callback urls given to hubs
+	// before ids were integers use it
+	feedID       string
+	feedURL      string
+	hub          string
+	topic        string
+	secret       string
+	state        string
+	leaseExpires int64
+}
+
+func websubEnabled() bool {
+	return feedieServer != nil && feedieServer.config.PublicURL != ""
+}
+
+func websubCallback(feedID string) string {
+	return strings.TrimSuffix(feedieServer.config.PublicURL, "/") + websubCallbackPath + feedID
+}
+
+// discoverHub returns the hub and self links a feed advertises, in its Link
+// headers or as <link rel="hub"> / <atom:link rel="hub"> elements.
+func discoverHub(header http.Header, body []byte) (string, string) {
+	var hub, self string
+	for _, v := range header.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(link, ";")
+			if !ok {
+				continue
+			}
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.ToLower(k) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(v, `"`)) {
+					if rel == "hub" && hub == "" {
+						hub = target
+					} else if rel == "self" && self == "" {
+						self = target
+					}
+				}
+			}
+		}
+	}
+
+	// links are in the feed header, stop at the first item
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if el.Name.Local == "item" || el.Name.Local == "entry" {
+			break
+		}
+		if el.Name.Local != "link" {
+			continue
+		}
+		var rel, href string
+		for _, a := range el.Attr {
+			switch a.Name.Local {
+			case "rel":
+				rel = a.Value
+			case "href":
+				href = a.Value
+			}
+		}
+		if rel == "hub" && hub == "" {
+			hub = href
+		} else if rel == "self" && self == "" {
+			self = href
+		}
+	}
+	return hub, self
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// websubSubscribe subscribes to feed at its hub, unless it has no hub or
+// already has an active subscription.
+func websubSubscribe(feed FeedieFeed) {
+	if !websubEnabled() || feed.hub == "" || DBWebSubActive(feed.Url) {
+		return
+	}
+	topic := feed.topic
+	if topic == "" {
+		topic = feed.Url
+	}
+	sub := websubSub{
+		feedID:  GetHashString(feed.Url),
+		feedURL: feed.Url,
+		hub:     feed.hub,
+		topic:   topic,
+		secret:  newSecret(),
+	}
+	websubRequest(sub)
+}
+
+// websubRequest asks the hub to (re)subscribe. The subscription is stored
+// as pending first, as some hubs verify it before answering the request.
+func websubRequest(sub websubSub) {
+	sub.state = websubPending
+	DBSetWebSub(sub)
+	form := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {sub.topic},
+		"hub.callback":      {websubCallback(sub.feedID)},
+		"hub.secret":        {sub.secret},
+		"hub.lease_seconds": {strconv.Itoa(websubLease)},
+	}
+	resp, err := postForm(sub.hub, form)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("hub responded %s", resp.Status)
+		}
+	}
+	if err != nil {
+		slog.Warn("unable to subscribe to WebSub hub", "topic", sub.topic, "hub", sub.hub, "err", err)
+		DBSetWebSubState(sub.feedID, websubFailed, 0)
+		return
+	}
+	slog.Info("requested WebSub subscription", "topic", sub.topic, "hub", sub.hub)
+}
+
+// websubUnsubscribe asks the hub of feed to stop pushing. The subscription
+// is read right away and the hub asked in the background, so the feed can
+// be deleted meanwhile: the callback confirms unsubscribing from feeds it
+// has no subscription for.
+func websubUnsubscribe(feedURL string) {
+	sub, ok := DBGetWebSub(GetHashString(feedURL))
+	if !ok || !websubEnabled() {
+		return
+	}
+	form := url.Values{
+		"hub.mode":     {"unsubscribe"},
+		"hub.topic":    {sub.topic},
+		"hub.callback": {websubCallback(sub.feedID)},
+	}
+	goJob(func() {
+		resp, err := postForm(sub.hub, form)
+		if err != nil {
+			slog.Warn("unable to unsubscribe from WebSub hub", "topic", sub.topic, "err", err)
+			return
+		}
+		resp.Body.Close()
+	})
+}
+
+// postForm posts form to a hub, giving up when the server shuts down.
+func postForm(hub string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(serverContext(), http.MethodPost, hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := http.Client{Timeout: websubTimeout}
+	return client.Do(req)
+}
+
+// websubThread renews subscriptions before their lease runs out.
+func websubThread(ctx context.Context) {
+	for {
+		for _, sub := range DBGetExpiringWebSubs(time.Now().Add(websubRenewBefore).Unix()) {
+			websubRequest(sub)
+		}
+		if !sleepCtx(ctx, websubRenewCheck) {
+			return
+		}
+	}
+}
+
+func websubCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	feedID := strings.TrimPrefix(r.URL.Path, websubCallbackPath)
+	switch r.Method {
+	case http.MethodGet:
+		websubVerify(w, r, feedID)
+	case http.MethodPost:
+		websubPush(w, r, feedID)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// websubVerify answers the hub's verification of intent.
+func websubVerify(w http.ResponseWriter, r *http.Request, feedID string) {
+	q := r.URL.Query()
+	mode, topic, challenge := q.Get("hub.mode"), q.Get("hub.topic"), q.Get("hub.challenge")
+	sub, ok := DBGetWebSub(feedID)
+
+	switch mode {
+	case "subscribe":
+		if !ok || sub.topic != topic || challenge == "" {
+			http.Error(w, "unknown subscription", http.StatusNotFound)
+			return
+		}
+		lease, err := strconv.ParseInt(q.Get("hub.lease_seconds"), 10, 64)
+		if err != nil || lease <= 0 {
+			lease = websubLease
+		}
+		DBSetWebSubState(feedID, websubSubscribed, time.Now().Unix()+lease)
+		slog.InfoContext(r.Context(), "WebSub subscription verified", "topic", topic, "lease_seconds", lease)
+	case "unsubscribe":
+		if ok && sub.topic == topic || challenge == "" {
+			http.Error(w, "subscription still wanted", http.StatusNotFound)
+			return
+		}
+	case "denied":
+		if ok {
+			slog.WarnContext(r.Context(), "WebSub subscription denied", "topic", topic, "reason", q.Get("hub.reason"))
+			DBSetWebSubState(feedID, websubFailed, 0)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "invalid hub.mode", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, challenge)
+}
+
+// websubPush ingests content pushed by the hub. Content with a missing or
+// invalid signature is acknowledged but dropped, as the spec requires.
+func websubPush(w http.ResponseWriter, r *http.Request, feedID string) {
+	sub, ok := DBGetWebSub(feedID)
+	if !ok {
+		// tells the hub to drop the subscription
+		http.Error(w, "unknown subscription", http.StatusGone)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, websubMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	if !validSignature(r.Header.Get("X-Hub-Signature"), sub.secret, body) {
+		slog.WarnContext(r.Context(), "dropping WebSub push with invalid signature", "feed", sub.feedURL)
+		return
+	}
+	parsed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("unable to parse WebSub push", "feed", sub.feedURL, "err", err)
+		return
+	}
+	feed := convertFeed(parsed, sub.feedURL)
+	goJob(func() {
+		DBAddFeedWithEntries(*feed)
+		fetchFullText(feed.Url)
+	})
+}
+
+func validSignature(header, secret string, body []byte) bool {
+	if secret == "" {
+		return true
+	}
+	method, sig, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func scanWebSub(row interface{ Scan(...any) error }) (websubSub, error) {
+	var s websubSub
+	err := row.Scan(&s.feedID, &s.feedURL, &s.hub, &s.topic, &s.secret, &s.state, &s.leaseExpires)
+	return s, err
+}
+
+const websubColumns = `f.hash, f.url, w.hub, w.topic, w.secret, w.state, w.lease_expires
+FROM websub AS w
+JOIN feeds AS f ON f.id = w.feed_id`
+
+func DBGetWebSub(feedID string) (websubSub, bool) {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	sub, err := scanWebSub(db.QueryRow(`SELECT `+websubColumns+` WHERE f.hash = ?`, feedID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sub, false
+		}
+		log.Fatal(err)
+	}
+	return sub, true
+}
+
+// DBWebSubActive reports whether feed has a verified, unexpired subscription.
+func DBWebSubActive(feedURL string) bool {
+	var count int
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT COUNT(*) FROM websub AS w
+JOIN feeds AS f ON f.id = w.feed_id
+WHERE f.url = ? AND w.state = ? AND w.lease_expires > ?`,
+		feedURL, websubSubscribed, time.Now().Unix()).Scan(&count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return count > 0
+}
+
+func DBGetExpiringWebSubs(before int64) []websubSub {
+	ret := []websubSub{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT `+websubColumns+`
+WHERE w.state = ? AND w.lease_expires < ?`, websubSubscribed, before)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sub, err := scanWebSub(rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, sub)
+	}
+	return ret
+}
+
+func DBSetWebSub(sub websubSub) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO websub (feed_id, hub, topic, secret, state, lease_expires)
+SELECT id, ?, ?, ?, ?, ? FROM feeds WHERE hash = ?
+ON CONFLICT(feed_id) DO UPDATE SET
+    hub = excluded.hub,
+    topic = excluded.topic,
+    secret = excluded.secret,
+    state = excluded.state,
+    lease_expires = excluded.lease_expires;`,
+		sub.hub, sub.topic, sub.secret, sub.state, sub.leaseExpires, sub.feedID)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DBSetWebSubState(feedID, state string, leaseExpires int64) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`UPDATE websub SET state = ?, lease_expires = ?
+WHERE feed_id = (SELECT id FROM feeds WHERE hash = ?)`,
+		state, leaseExpires, feedID)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
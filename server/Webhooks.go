@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Webhooks are POSTed to when new entries are ingested. A webhook is scoped
+// like a filter rule, to everything, a tag or a feed, or fires when a given
+// rule matches. Its payload is a text/template that must render to JSON;
+// every delivery attempt is recorded in webhook_deliveries. The entries of a
+// feed's first ingest are its backlog rather than news, so they don't fire.
+
+const webhookScopeRule = "rule"
+
+const webhookTimeout = 10 * time.Second
+
+const webhookMaxAttempts = 5
+
+const webhookConcurrency = 4
+
+// delay before the first retry, doubled for each further attempt
+var webhookRetryDelay = 2 * time.Second
+
+// the default payload has a "text" field so it can be posted straight to
+// Slack or Matrix incoming webhooks
+const defaultWebhookTemplate = `{
+  "text": {{json (printf "%s: %s %s" .Feed.Title .Entry.Title .Link)}},
+  "feed": {"title": {{json .Feed.Title}}, "url": {{json .Feed.Url}}},
+  "entry": {
+    "title": {{json .Entry.Title}},
+    "author": {{json .Entry.Author}},
+    "link": {{json .Link}},
+    "published": {{.Entry.Published}}
+  }
+}`
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type FeedieWebhook struct {
+	ID         string
+	URL        string
+	Scope      string // global, tag, feed or rule
+	ScopeValue string // tag name, feed url or rule id
+	Template   string // payload template, the default if empty
+	tmpl       *template.Template
+}
+
+type FeedieDelivery struct {
+	WebhookID string
+	EntryID   int64
+	Attempt   int
+	Status    int
+	Error     string
+	Time      int64
+}
+
+// webhookPayload is the data webhook templates are executed with.
+type webhookPayload struct {
+	Feed  FeedieFeed
+	Entry FeedieEntry
+	Link  string
+}
+
+type webhookJob struct {
+	hook    FeedieWebhook
+	entryID int64
+	payload webhookPayload
+}
+
+func (h FeedieWebhook) getHashString() string {
+	return strings.Join([]string{h.URL, h.Scope, h.ScopeValue, h.Template}, "\x00")
+}
+
+// validate checks the webhook's fields and parses its template.
+func (h *FeedieWebhook) validate() error {
+	if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+		return fmt.Errorf("invalid webhook url: %q", h.URL)
+	}
+	switch h.Scope {
+	case ruleScopeGlobal:
+		h.ScopeValue = ""
+	case ruleScopeTag, ruleScopeFeed, webhookScopeRule:
+		if h.ScopeValue == "" {
+			return fmt.Errorf("webhook scope %s requires a value", h.Scope)
+		}
+	default:
+		return fmt.Errorf("invalid webhook scope: %q", h.Scope)
+	}
+	text := h.Template
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	tmpl, err := template.New(h.URL).Funcs(webhookFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	h.tmpl = tmpl
+	return nil
+}
+
+func (h FeedieWebhook) render(p webhookPayload) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("webhook template did not render valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// fires reports whether h is triggered by a new entry of a feed in tags that
+// matched rules.
+func (h FeedieWebhook) fires(feed FeedieFeed, tags map[string]bool, matched []FeedieRule) bool {
+	switch h.Scope {
+	case ruleScopeGlobal:
+		return true
+	case ruleScopeFeed:
+		return h.ScopeValue == feed.Url
+	case ruleScopeTag:
+		if tags[h.ScopeValue] {
+			return true
+		}
+		// entries tagged by a rule count as members of the tag
+		for _, r := range matched {
+			if r.Action == ruleActionTag && r.ActionValue == h.ScopeValue {
+				return true
+			}
+		}
+	case webhookScopeRule:
+		for _, r := range matched {
+			if r.ID == h.ScopeValue {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func scanWebhooks(rows *sql.Rows) []FeedieWebhook {
+	ret := []FeedieWebhook{}
+	for rows.Next() {
+		var h FeedieWebhook
+		if err := rows.Scan(&h.ID, &h.URL, &h.Scope, &h.ScopeValue, &h.Template); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, h)
+	}
+	return ret
+}
+
+// loadWebhooks returns the valid webhooks and the names of the tags feed is
+// a member of, for matching against its new entries.
+func loadWebhooks(tx *sql.Tx, feed FeedieFeed) ([]FeedieWebhook, map[string]bool) {
+	rows, err := tx.Query(`SELECT id, url, scope, scope_value, template FROM webhooks`)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	hooks := []FeedieWebhook{}
+	for _, h := range scanWebhooks(rows) {
+		if err := h.validate(); err != nil {
+			slog.Warn("skipping invalid webhook", "webhook", h.ID, "err", err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	rows.Close()
+
+	tags := map[string]bool{}
+	if len(hooks) == 0 {
+		return hooks, tags
+	}
+	rows, err = tx.Query(`SELECT t.name FROM tags AS t
+JOIN tag_members AS tm ON tm.tag_id = t.id
+WHERE tm.feed_id = ?`, feed.ID)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			tx.Rollback()
+			log.Fatal(err)
+		}
+		tags[name] = true
+	}
+	return hooks, tags
+}
+
+// webhookJobs returns a delivery for every webhook fired by a new entry.
+// Entries hidden by a rule don't fire webhooks.
+func webhookJobs(hooks []FeedieWebhook, tags map[string]bool, matched []FeedieRule,
+	feed FeedieFeed, entryID int64, entry FeedieEntry) []webhookJob {
+	for _, r := range matched {
+		if r.Action == ruleActionHide {
+			return nil
+		}
+	}
+	jobs := []webhookJob{}
+	payload := webhookPayload{
+		Feed:  FeedieFeed{Title: feed.Title, Url: feed.Url},
+		Entry: entry,
+		Link:  entry.primaryLink(),
+	}
+	for _, h := range hooks {
+		if h.fires(feed, tags, matched) {
+			jobs = append(jobs, webhookJob{hook: h, entryID: entryID, payload: payload})
+		}
+	}
+	return jobs
+}
+
+// webhooksInFlight lets commands that exit after ingesting wait for their
+// deliveries.
+var webhooksInFlight sync.WaitGroup
+
+// webhookSlots caps the deliveries made at once, a busy refresh queueing
+// the rest.
+var webhookSlots = make(chan struct{}, webhookConcurrency)
+
+func deliverWebhooks(jobs []webhookJob) {
+	for _, job := range jobs {
+		webhooksInFlight.Add(1)
+		go func() {
+			defer webhooksInFlight.Done()
+			webhookSlots <- struct{}{}
+			defer func() { <-webhookSlots }()
+			deliverWebhook(job)
+		}()
+	}
+}
+
+// deliverWebhook posts job, retrying with exponential backoff on network
+// errors, 429 and 5xx responses. It returns the last attempt.
+func deliverWebhook(job webhookJob) FeedieDelivery {
+	body, err := job.hook.render(job.payload)
+	if err != nil {
+		d := FeedieDelivery{WebhookID: job.hook.ID, EntryID: job.entryID, Attempt: 1,
+			Error: err.Error(), Time: time.Now().Unix()}
+		DBAddDelivery(d)
+		return d
+	}
+	delay := webhookRetryDelay
+	var d FeedieDelivery
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		d = postWebhook(job.hook.URL, body)
+		d.WebhookID, d.EntryID, d.Attempt = job.hook.ID, job.entryID, attempt
+		DBAddDelivery(d)
+		retry := d.Error != "" && d.Status == 0 || d.Status == http.StatusTooManyRequests || d.Status >= 500
+		if !retry {
+			break
+		}
+		if attempt < webhookMaxAttempts {
+			// shutting down gives up on retries
+			if !sleepCtx(serverContext(), delay) {
+				break
+			}
+			delay *= 2
+		}
+	}
+	if d.Error != "" {
+		slog.Warn("webhook delivery failed", "url", job.hook.URL, "attempts", d.Attempt, "err", d.Error)
+	}
+	return d
+}
+
+func postWebhook(url string, body []byte) FeedieDelivery {
+	d := FeedieDelivery{Time: time.Now().Unix()}
+	client := http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	d.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		d.Error = resp.Status
+	}
+	return d
+}
+
+func DBGetWebhooks() []FeedieWebhook {
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT id, url, scope, scope_value, template
+FROM webhooks ORDER BY scope, scope_value`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	return scanWebhooks(rows)
+}
+
+func DBGetWebhook(id string) (FeedieWebhook, bool) {
+	var h FeedieWebhook
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	err := db.QueryRow(`SELECT id, url, scope, scope_value, template FROM webhooks WHERE id = ?`, id).
+		Scan(&h.ID, &h.URL, &h.Scope, &h.ScopeValue, &h.Template)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return h, false
+		}
+		log.Fatal(err)
+	}
+	return h, true
+}
+
+// DBAddWebhook inserts hook, or updates the webhook with the same id if
+// hook.ID is set. It returns the id the webhook was stored under.
+func DBAddWebhook(hook FeedieWebhook) (string, error) {
+	if err := hook.validate(); err != nil {
+		return "", err
+	}
+	if hook.ID == "" {
+		hook.ID = GetHashString(hook.getHashString())
+	}
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO webhooks (id, url, scope, scope_value, template)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET
+    url = excluded.url,
+    scope = excluded.scope,
+    scope_value = excluded.scope_value,
+    template = excluded.template;`,
+		hook.ID, hook.URL, hook.Scope, hook.ScopeValue, hook.Template)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hook.ID, nil
+}
+
+func DBDelWebhook(id string) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DBAddDelivery(d FeedieDelivery) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+	_, err := db.Exec(`INSERT INTO webhook_deliveries
+(webhook_id, entry_id, attempt, status, error, time)
+VALUES (?, ?, ?, ?, ?, ?)`, d.WebhookID, d.EntryID, d.Attempt, d.Status, d.Error, d.Time)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func DBGetDeliveries(webhookID string, limit int) []FeedieDelivery {
+	ret := []FeedieDelivery{}
+	dbMu.RLock()
+	defer dbMu.RUnlock()
+	rows, err := db.Query(`SELECT webhook_id, entry_id, attempt, status, error, time
+FROM webhook_deliveries
+WHERE webhook_id = ?
+ORDER BY id DESC
+LIMIT ?`, webhookID, limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d FeedieDelivery
+		if err := rows.Scan(&d.WebhookID, &d.EntryID, &d.Attempt, &d.Status, &d.Error, &d.Time); err != nil {
+			log.Fatal(err)
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+func writeJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("unable to encode response", "err", err)
+	}
+}
+
+func getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, DBGetWebhooks())
+}
+
+func addWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	hook := FeedieWebhook{
+		ID:         q.Get("id"),
+		URL:        q.Get("url"),
+		Scope:      q.Get("scope"),
+		ScopeValue: q.Get("scope_value"),
+		Template:   q.Get("template"),
+	}
+	id, err := DBAddWebhook(hook)
+	if err != nil {
+		slog.WarnContext(r.Context(), "invalid webhook", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{"ID": id})
+}
+
+func delWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		slog.WarnContext(r.Context(), "id value empty")
+		return
+	}
+
+	DBDelWebhook(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// testWebhookHandler delivers a sample entry to a webhook once, without
+// retries, and returns the result.
+func testWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	hook, ok := DBGetWebhook(id)
+	if !ok {
+		slog.WarnContext(r.Context(), "unknown webhook", "id", id)
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+	if err := hook.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := newEntry("Test entry", "Feedie", time.Now().Unix(), "<p>This is a test delivery.</p>", "")
+	entry.Links = append(entry.Links, FeedieLink{URL: "https://example.com/feedie-test", Type: "text/html"})
+	payload := webhookPayload{
+		Feed:  FeedieFeed{Title: "Feedie", Url: "https://example.com/feedie-test.xml"},
+		Entry: *entry,
+		Link:  entry.primaryLink(),
+	}
+	d := FeedieDelivery{WebhookID: hook.ID, Attempt: 1, Time: time.Now().Unix()}
+	body, err := hook.render(payload)
+	if err == nil {
+		d = postWebhook(hook.URL, body)
+		d.WebhookID, d.Attempt = hook.ID, 1
+	} else {
+		d.Error = err.Error()
+	}
+	DBAddDelivery(d)
+	writeJSON(w, d)
+}
+
+func getDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, DBGetDeliveries(id, limit))
+}
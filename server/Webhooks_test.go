@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookStandIn records the payloads posted to it and answers with the
+// queued status codes, then 200.
+type webhookStandIn struct {
+	mu       sync.Mutex
+	statuses []int
+	payloads []map[string]any
+	received chan struct{}
+}
+
+func newWebhookStandIn(t *testing.T, statuses ...int) (*webhookStandIn, *httptest.Server) {
+	s := &webhookStandIn{statuses: statuses, received: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("payload is not JSON: %v: %s", err, body)
+		}
+		s.mu.Lock()
+		s.payloads = append(s.payloads, payload)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(status)
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func (s *webhookStandIn) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d webhook deliveries", i, n)
+		}
+	}
+}
+
+func initTestDB(t testing.TB) {
+	t.Helper()
+	DBInit(filepath.Join(t.TempDir(), "feedie.db"))
+	t.Cleanup(func() { db.Close() })
+}
+
+func testFeed(url string, titles ...string) FeedieFeed {
+	entries := []FeedieEntry{}
+	for i, title := range titles {
+		e := newEntry(title, "author", int64(1000+i), "", "")
+		e.GUID = url + "#" + title
+		e.Links = append(e.Links, FeedieLink{URL: url + "/" + title, Type: "text/html"})
+		entries = append(entries, *e)
+	}
+	return *newFeed("Status", url, entries)
+}
+
+func TestWebhookFiresForNewEntriesOfTag(t *testing.T) {
+	initTestDB(t)
+	standIn, srv := newWebhookStandIn(t)
+
+	feed := testFeed("http://status.example.com/feed", "outage")
+	DBAddTag("outages")
+	DBAddFeedWithEntries(testFeed(feed.Url, "maintenance"))
+	DBAddMembership("outages", feed.Url)
+	id, err := DBAddWebhook(FeedieWebhook{URL: srv.URL, Scope: ruleScopeTag, ScopeValue: "outages",
+		Template: `{"msg": {{json .Entry.Title}}, "feed": {{json .Feed.Title}}, "link": {{json .Link}}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	DBAddFeedWithEntries(feed)
+	standIn.wait(t, 1)
+	got := standIn.payloads[0]
+	if got["msg"] != "outage" || got["feed"] != "Status" || got["link"] != feed.Url+"/outage" {
+		t.Errorf("unexpected payload %v", got)
+	}
+
+	// refreshing the same entries must not fire again
+	DBAddFeedWithEntries(feed)
+	select {
+	case <-standIn.received:
+		t.Error("webhook fired for an entry that was already ingested")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	deliveries := DBGetDeliveries(id, 10)
+	if len(deliveries) != 1 || deliveries[0].Status != http.StatusOK || deliveries[0].Error != "" {
+		t.Errorf("unexpected delivery log %+v", deliveries)
+	}
+}
+
+func TestWebhookSkipsFirstIngest(t *testing.T) {
+	initTestDB(t)
+	standIn, srv := newWebhookStandIn(t)
+	if _, err := DBAddWebhook(FeedieWebhook{URL: srv.URL, Scope: ruleScopeGlobal}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the entries of a new feed, and of one imported without entries, are
+	// its backlog
+	DBAddFeedWithEntries(testFeed("http://a.example.com/feed", "one", "two"))
+	DBAddFeed(FeedieFeed{Title: "Imported", Url: "http://b.example.com/feed"})
+	DBAddFeedWithEntries(testFeed("http://b.example.com/feed", "one"))
+	webhooksInFlight.Wait()
+	if len(standIn.payloads) != 0 {
+		t.Fatalf("first ingests fired %d webhooks", len(standIn.payloads))
+	}
+
+	DBAddFeedWithEntries(testFeed("http://a.example.com/feed", "one", "two", "three"))
+	standIn.wait(t, 1)
+	webhooksInFlight.Wait()
+	if len(standIn.payloads) != 1 {
+		t.Errorf("one new entry fired %d webhooks", len(standIn.payloads))
+	}
+}
+
+func TestWebhookDeliveriesAreCapped(t *testing.T) {
+	initTestDB(t)
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		most = max(most, inFlight)
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	id, _ := DBAddWebhook(FeedieWebhook{URL: srv.URL, Scope: ruleScopeGlobal})
+	hook, _ := DBGetWebhook(id)
+	hook.validate()
+	// deliveries are logged against stored entries
+	feed := numberedFeed("a.example.com", 1000, 3*webhookConcurrency)
+	DBAddFeedWithEntries(feed)
+	jobs := []webhookJob{}
+	for _, e := range DBGetByFeedTimeOrdered(feed, ASC, allEntries) {
+		jobs = append(jobs, webhookJob{hook: hook, entryID: e.ID, payload: webhookPayload{Entry: e}})
+	}
+	deliverWebhooks(jobs)
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+	webhooksInFlight.Wait()
+	if most != webhookConcurrency {
+		t.Errorf("%d deliveries were made at once, want %d", most, webhookConcurrency)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	initTestDB(t)
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = 10 * time.Millisecond
+	standIn, srv := newWebhookStandIn(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+
+	id, err := DBAddWebhook(FeedieWebhook{URL: srv.URL, Scope: ruleScopeGlobal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, _ := DBGetWebhook(id)
+	if err := hook.validate(); err != nil {
+		t.Fatal(err)
+	}
+	entry := newEntry("title", "author", 1, "", "")
+	d := deliverWebhook(webhookJob{hook: hook, entryID: 1,
+		payload: webhookPayload{Feed: FeedieFeed{Title: "feed"}, Entry: *entry}})
+	standIn.wait(t, 3)
+
+	if d.Attempt != 3 || d.Status != http.StatusOK {
+		t.Errorf("last delivery = %+v, want success on attempt 3", d)
+	}
+	if text, _ := standIn.payloads[0]["text"].(string); text != "feed: title " {
+		t.Errorf("default payload text = %q", text)
+	}
+	deliveries := DBGetDeliveries(id, 10)
+	if len(deliveries) != 3 {
+		t.Fatalf("logged %d deliveries, want 3", len(deliveries))
+	}
+	if deliveries[2].Status != http.StatusServiceUnavailable || deliveries[2].Attempt != 1 {
+		t.Errorf("first attempt logged as %+v", deliveries[2])
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	initTestDB(t)
+	standIn, srv := newWebhookStandIn(t, http.StatusBadRequest)
+
+	id, _ := DBAddWebhook(FeedieWebhook{URL: srv.URL, Scope: ruleScopeGlobal})
+	hook, _ := DBGetWebhook(id)
+	hook.validate()
+	d := deliverWebhook(webhookJob{hook: hook, payload: webhookPayload{Entry: *newEmptyEntry()}})
+	standIn.wait(t, 1)
+	if d.Attempt != 1 || d.Status != http.StatusBadRequest || d.Error == "" {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+}
+
+func TestWebhookTemplateMustRenderJSON(t *testing.T) {
+	hook := FeedieWebhook{URL: "http://localhost", Scope: ruleScopeGlobal, Template: `{"title": {{.Entry.Title}}}`}
+	if err := hook.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hook.render(webhookPayload{Entry: FeedieEntry{Title: "not quoted"}}); err == nil {
+		t.Error("expected an error for a template rendering invalid JSON")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ext "github.com/mmcdole/gofeed/extensions"
+)
+
+// YouTube publishes an Atom feed for every channel and playlist, but only
+// under feeds/videos.xml with the channel or playlist id. resolveYouTubeURL
+// turns the URLs people actually copy from the browser into those feeds.
+
+const youtubeFeedBase = "https://www.youtube.com/feeds/videos.xml"
+
+var youtubeChannelIDRe = regexp.MustCompile(`^UC[\w-]{22}$`)
+
+// the canonical link of a channel page carries its id, the embedded page
+// data is a fallback for pages without one
+var youtubeCanonicalRe = regexp.MustCompile(`<link rel="canonical" href="https://www\.youtube\.com/channel/(UC[\w-]{22})"`)
+var youtubeExternalIDRe = regexp.MustCompile(`"(?:externalId|channelId)":"(UC[\w-]{22})"`)
+
+func isYouTubeHost(host string) bool {
+	host = strings.TrimPrefix(strings.ToLower(host), "www.")
+	host = strings.TrimPrefix(host, "m.")
+	return host == "youtube.com" || host == "youtu.be"
+}
+
+// resolveYouTubeURL returns the Atom feed for a YouTube channel, handle or
+// playlist URL. Other URLs, including YouTube feed URLs, are returned as is.
+func resolveYouTubeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || !isYouTubeHost(u.Host) {
+		return raw, nil
+	}
+	if strings.HasPrefix(u.Path, "/feeds/") {
+		return raw, nil
+	}
+	if list := u.Query().Get("list"); list != "" {
+		return youtubeFeedBase + "?playlist_id=" + url.QueryEscape(list), nil
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "channel" && youtubeChannelIDRe.MatchString(parts[1]):
+		return youtubeFeedBase + "?channel_id=" + parts[1], nil
+	case strings.HasPrefix(parts[0], "@"),
+		len(parts) >= 2 && (parts[0] == "c" || parts[0] == "user"):
+		id, err := lookupYouTubeChannelID(fmt.Sprintf("https://www.youtube.com/%s", strings.Join(parts[:min(len(parts), 2)], "/")))
+		if err != nil {
+			return "", err
+		}
+		return youtubeFeedBase + "?channel_id=" + id, nil
+	}
+	return "", fmt.Errorf("unsupported YouTube url: %s", raw)
+}
+
+// lookupYouTubeChannelID fetches a channel page and reads its channel id.
+func lookupYouTubeChannelID(pageURL string) (string, error) {
+	// skip the cookie consent interstitial served to EU visitors
+	header := http.Header{"Cookie": {"CONSENT=YES+"}}
+	resp, err := httpFetcher.Get(serverContext(), pageURL, header)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", err
+	}
+	for _, re := range []*regexp.Regexp{youtubeCanonicalRe, youtubeExternalIDRe} {
+		if m := re.FindSubmatch(body); m != nil {
+			return string(m[1]), nil
+		}
+	}
+	return "", errors.New("no channel id found on " + pageURL)
+}
+
+// parseMediaStats reads the view count, star rating and duration that
+// YouTube and other video feeds publish through Media RSS.
+func parseMediaStats(media map[string][]ext.Extension, outItem *FeedieEntry) {
+	video := FeedieVideo{}
+	found := false
+	if groups, ok := media["group"]; ok && len(groups) > 0 {
+		g := groups[0]
+		for _, community := range g.Children["community"] {
+			if stats, ok := community.Children["statistics"]; ok && len(stats) > 0 {
+				if v, err := strconv.ParseInt(stats[0].Attrs["views"], 10, 64); err == nil {
+					video.Views = v
+					found = true
+				}
+			}
+			if ratings, ok := community.Children["starRating"]; ok && len(ratings) > 0 {
+				if v, err := strconv.ParseFloat(ratings[0].Attrs["average"], 64); err == nil {
+					video.RatingAverage = v
+					found = true
+				}
+				if v, err := strconv.ParseInt(ratings[0].Attrs["count"], 10, 64); err == nil {
+					video.RatingCount = v
+				}
+			}
+		}
+		for _, content := range g.Children["content"] {
+			if d := parseDuration(content.Attrs["duration"]); d > 0 {
+				video.Duration = d
+				found = true
+			}
+		}
+	}
+	for _, content := range media["content"] {
+		if d := parseDuration(content.Attrs["duration"]); d > 0 && video.Duration == 0 {
+			video.Duration = d
+			found = true
+		}
+	}
+	if found {
+		outItem.Video = &video
+	}
+}
+
+// upsertVideo stores the media statistics of an ingested entry.
+func upsertVideo(tx *sql.Tx, entryID int64, v *FeedieVideo) {
+	_, err := tx.Exec(`INSERT INTO videos
+(entry_id, views, rating_average, rating_count, duration)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(entry_id) DO UPDATE SET
+    views = excluded.views,
+    rating_average = excluded.rating_average,
+    rating_count = excluded.rating_count,
+    duration = excluded.duration;`,
+		entryID, v.Views, v.RatingAverage, v.RatingCount, v.Duration)
+	if err != nil {
+		tx.Rollback()
+		log.Fatal(err)
+	}
+}
+
+func attachVideos(byID map[int64]*FeedieEntry, ids []any) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	rows, err := db.Query(fmt.Sprintf(`SELECT entry_id, views, rating_average, rating_count, duration
+FROM videos WHERE entry_id IN (%s)`, placeholders), ids...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		v := FeedieVideo{}
+		if err := rows.Scan(&id, &v.Views, &v.RatingAverage, &v.RatingCount, &v.Duration); err != nil {
+			log.Fatal(err)
+		}
+		if e, ok := byID[id]; ok {
+			e.Video = &v
+		}
+	}
+}